@@ -0,0 +1,302 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+// Package admin provides an HTTP API to inspect and manage the tables of a
+// cacher registry of a running process.
+//
+// All routes are relative to the handler's prefix:
+//
+//	GET    /tables                        list all tables
+//	GET    /tables/{table}                counts, config and stats of a table
+//	GET    /tables/{table}/items/{key}    a single item
+//	DELETE /tables/{table}/items/{key}    delete a single item
+//	GET    /tables/{table}/most-accessed  the most accessed items (?count=10)
+//	POST   /tables/{table}/flush          delete all items
+//	POST   /tables/{table}/expire         run an expiration check
+//
+// Table names and keys are path segments and must be escaped accordingly.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+// Handler serves the admin API.
+type Handler struct {
+	// Prefix is stripped from request paths, e.g. "/debug/cacher".
+	Prefix string
+	// Registry holds the served tables. cacher.DefaultRegistry() is used if
+	// nil.
+	Registry *cacher.Registry
+	// Auth, if set, is called before every request. Returning false rejects
+	// the request; Auth is expected to have written a response then.
+	Auth func(w http.ResponseWriter, r *http.Request) bool
+	// Render converts item data into a value for the JSON response.
+	// DefaultRender is used if nil.
+	Render func(data interface{}) interface{}
+	// ParseKey converts a key taken from the URL into a cache key. The
+	// string itself is used if nil.
+	ParseKey func(table, key string) (interface{}, error)
+}
+
+// NewHandler returns a handler mounted under prefix.
+func NewHandler(prefix string) *Handler {
+	return &Handler{Prefix: prefix}
+}
+
+// registry returns the registry of the served tables.
+func (h *Handler) registry() *cacher.Registry {
+	if h.Registry == nil {
+		return cacher.DefaultRegistry()
+	}
+	return h.Registry
+}
+
+// DefaultRender passes data through if it can be encoded as JSON and falls
+// back to its fmt.Sprint representation otherwise. Byte slices are rendered
+// as strings.
+func DefaultRender(data interface{}) interface{} {
+	switch d := data.(type) {
+	case []byte:
+		return string(d)
+	case error:
+		return d.Error()
+	case fmt.Stringer:
+		return d.String()
+	}
+	if _, err := json.Marshal(data); err != nil {
+		return fmt.Sprint(data)
+	}
+	return data
+}
+
+// TableInfo describes a table.
+type TableInfo struct {
+	Name            string `json:"name"`
+	Count           int    `json:"count"`
+	CleanupInterval string `json:"cleanupInterval,omitempty"`
+	NullData        bool   `json:"nullData"`
+	DataLoader      bool   `json:"dataLoader"`
+
+	Stats *cacher.StatsSnapshot `json:"stats,omitempty"`
+}
+
+// ItemInfo describes a single cached item.
+type ItemInfo struct {
+	Key         string      `json:"key"`
+	Data        interface{} `json:"data"`
+	LifeSpan    string      `json:"lifeSpan"`
+	ExpiresAt   *time.Time  `json:"expiresAt,omitempty"`
+	CreatedOn   time.Time   `json:"createdOn"`
+	AccessedOn  time.Time   `json:"accessedOn"`
+	AccessCount int64       `json:"accessCount"`
+}
+
+var errNotFound = errors.New("not found")
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Auth != nil && !h.Auth(w, r) {
+		return
+	}
+
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, h.Prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	var segments []string
+	for _, s := range strings.Split(strings.Trim(path[len(h.Prefix):], "/"), "/") {
+		s, err := url.PathUnescape(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	switch {
+	case len(segments) == 0 || (len(segments) == 1 && segments[0] == "tables"):
+		h.route(w, r, http.MethodGet, h.listTables)
+	case segments[0] != "tables":
+		http.NotFound(w, r)
+	case len(segments) == 2:
+		h.withTable(w, r, segments[1], http.MethodGet, h.tableInfo)
+	case len(segments) == 3 && segments[2] == "most-accessed":
+		h.withTable(w, r, segments[1], http.MethodGet, h.mostAccessed)
+	case len(segments) == 3 && segments[2] == "flush":
+		h.withTable(w, r, segments[1], http.MethodPost, h.flush)
+	case len(segments) == 3 && segments[2] == "expire":
+		h.withTable(w, r, segments[1], http.MethodPost, h.expire)
+	case len(segments) == 4 && segments[2] == "items":
+		h.item(w, r, segments[1], segments[3])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// route calls f if the request uses method.
+func (h *Handler) route(w http.ResponseWriter, r *http.Request, method string, f func(http.ResponseWriter, *http.Request)) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f(w, r)
+}
+
+// withTable looks up the named table and calls f with it.
+func (h *Handler) withTable(w http.ResponseWriter, r *http.Request, name, method string, f func(http.ResponseWriter, *http.Request, *cacher.CacheTable)) {
+	h.route(w, r, method, func(w http.ResponseWriter, r *http.Request) {
+		table, ok := h.registry().Lookup(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, errNotFound)
+			return
+		}
+		f(w, r, table)
+	})
+}
+
+func (h *Handler) listTables(w http.ResponseWriter, r *http.Request) {
+	tables := []TableInfo{}
+	for _, name := range h.registry().Tables() {
+		if table, ok := h.registry().Lookup(name); ok {
+			tables = append(tables, TableInfo{Name: name, Count: table.Count()})
+		}
+	}
+	writeJSON(w, http.StatusOK, tables)
+}
+
+func (h *Handler) tableInfo(w http.ResponseWriter, r *http.Request, table *cacher.CacheTable) {
+	writeJSON(w, http.StatusOK, describe(table))
+}
+
+// describe returns the info about table.
+func describe(table *cacher.CacheTable) TableInfo {
+	info := TableInfo{
+		Name:       table.Name(),
+		Count:      table.Count(),
+		NullData:   table.NullDataEnabled(),
+		DataLoader: table.DataLoaderEnabled(),
+	}
+	if d := table.CleanupInterval(); d > 0 {
+		info.CleanupInterval = d.String()
+	}
+	stats := table.Stats().Snapshot()
+	info.Stats = &stats
+	return info
+}
+
+func (h *Handler) mostAccessed(w http.ResponseWriter, r *http.Request, table *cacher.CacheTable) {
+	count := int64(10)
+	if s := r.URL.Query().Get("count"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid count"))
+			return
+		}
+		count = n
+	}
+
+	items := []ItemInfo{}
+	for _, item := range table.MostAccessed(count) {
+		items = append(items, h.describeItem(item))
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (h *Handler) flush(w http.ResponseWriter, r *http.Request, table *cacher.CacheTable) {
+	table.Flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) expire(w http.ResponseWriter, r *http.Request, table *cacher.CacheTable) {
+	table.ExpirationCheck()
+	writeJSON(w, http.StatusOK, describe(table))
+}
+
+func (h *Handler) item(w http.ResponseWriter, r *http.Request, name, rawKey string) {
+	table, ok := h.registry().Lookup(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	var key interface{} = rawKey
+	if h.ParseKey != nil {
+		var err error
+		if key, err = h.ParseKey(name, rawKey); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// Inspecting a key must neither load it nor count as an access.
+		item, err := table.Peek(key)
+		if err != nil {
+			writeError(w, http.StatusNotFound, errNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, h.describeItem(item))
+	case http.MethodDelete:
+		if _, err := table.Delete(key); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) describeItem(item *cacher.CacheItem) ItemInfo {
+	render := h.Render
+	if render == nil {
+		render = DefaultRender
+	}
+	info := ItemInfo{
+		Key:         fmt.Sprint(item.Key()),
+		Data:        render(item.Data()),
+		LifeSpan:    item.LifeSpan().String(),
+		CreatedOn:   item.CreatedOn(),
+		AccessedOn:  item.AccessedOn(),
+		AccessCount: item.AccessCount(),
+	}
+	if expiresAt := item.ExpiresAt(); !expiresAt.IsZero() {
+		info.ExpiresAt = &expiresAt
+	}
+	return info
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,149 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+func request(t *testing.T, h http.Handler, method, path string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code
+}
+
+func TestTables(t *testing.T) {
+	r := cacher.NewRegistry()
+	defer r.CloseAll()
+	table := r.New("testAdmin", time.Minute)
+	table.Set("a", 0, "1")
+	table.Get("a")
+	h := NewHandler("/debug/cacher")
+	h.Registry = r
+
+	var tables []TableInfo
+	if code := request(t, h, "GET", "/debug/cacher/tables", &tables); code != http.StatusOK {
+		t.Fatal("Listing tables failed with", code)
+	}
+	found := false
+	for _, info := range tables {
+		found = found || (info.Name == "testAdmin" && info.Count == 1)
+	}
+	if !found {
+		t.Error("Table missing from listing", tables)
+	}
+
+	var info TableInfo
+	request(t, h, "GET", "/debug/cacher/tables/testAdmin", &info)
+	if info.Count != 1 || info.CleanupInterval != "1m0s" {
+		t.Error("Unexpected table info", info)
+	}
+	if info.Stats == nil || info.Stats.Hits != 1 || info.Stats.Sets != 1 {
+		t.Error("Unexpected table stats", info.Stats)
+	}
+	if code := request(t, h, "GET", "/debug/cacher/tables/nosuchtable", nil); code != http.StatusNotFound {
+		t.Error("Expected 404 for unknown table, got", code)
+	}
+	if code := request(t, h, "POST", "/debug/cacher/tables/testAdmin/flush", nil); code != http.StatusNoContent {
+		t.Error("Flushing failed with", code)
+	}
+	if table.Count() != 0 {
+		t.Error("Table wasn't flushed")
+	}
+	if code := request(t, h, "GET", "/debug/cacher/tables/testAdmin/flush", nil); code != http.StatusMethodNotAllowed {
+		t.Error("Expected 405 for GET flush, got", code)
+	}
+}
+
+func TestItems(t *testing.T) {
+	table := cacher.New("testAdminItems", time.Minute)
+	table.Flush()
+	table.Set("a/b", 0, []byte("bytes"))
+	table.Set(42, 0, struct{ N int }{7})
+	for i := 0; i < 3; i++ {
+		table.Get(42)
+	}
+	h := NewHandler("")
+	h.ParseKey = func(table, key string) (interface{}, error) {
+		if n, err := strconv.Atoi(key); err == nil {
+			return n, nil
+		}
+		return key, nil
+	}
+
+	var item ItemInfo
+	request(t, h, "GET", "/tables/testAdminItems/items/a%2Fb", &item)
+	if item.Key != "a/b" || item.Data != "bytes" || item.ExpiresAt != nil {
+		t.Error("Unexpected item", item)
+	}
+	expiring := table.Set("expiring", time.Hour, "data")
+	request(t, h, "GET", "/tables/testAdminItems/items/expiring", &item)
+	if item.ExpiresAt == nil || !item.ExpiresAt.Equal(expiring.ExpiresAt()) {
+		t.Error("Unexpected expiry", item.ExpiresAt)
+	}
+	hits := table.Stats().Snapshot().Hits
+	request(t, h, "GET", "/tables/testAdminItems/items/42", &item)
+	if item.AccessCount != 3 || table.Stats().Snapshot().Hits != hits {
+		t.Error("Inspecting an item counted as an access")
+	}
+
+	var items []ItemInfo
+	request(t, h, "GET", "/tables/testAdminItems/most-accessed?count=1", &items)
+	if len(items) != 1 || items[0].Key != "42" || items[0].AccessCount != 3 {
+		t.Error("Unexpected most accessed items", items)
+	}
+
+	if code := request(t, h, "DELETE", "/tables/testAdminItems/items/42", nil); code != http.StatusNoContent {
+		t.Error("Deleting failed with", code)
+	}
+	if code := request(t, h, "GET", "/tables/testAdminItems/items/42", nil); code != http.StatusNotFound {
+		t.Error("Expected 404 for deleted item, got", code)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	h := NewHandler("")
+	h.Auth = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	if code := request(t, h, "GET", "/tables", nil); code != http.StatusForbidden {
+		t.Error("Expected 403 without credentials, got", code)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := cacher.NewRegistry()
+	defer r.CloseAll()
+	r.New("testAdminRegistry", time.Minute).Set("a", 0, "1")
+	h := &Handler{Prefix: "/debug/cacher", Registry: r}
+
+	var tables []TableInfo
+	request(t, h, "GET", "/debug/cacher/tables", &tables)
+	if len(tables) != 1 || tables[0].Name != "testAdminRegistry" || tables[0].Count != 1 {
+		t.Error("Unexpected tables", tables)
+	}
+	if code := request(t, NewHandler("/debug/cacher"), "GET", "/debug/cacher/tables/testAdminRegistry", nil); code != http.StatusNotFound {
+		t.Error("Default handler served a table of another registry:", code)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SetMany adds all key/value pairs of items to the cache with the same
+// lifeSpan, taking the table lock only once. It returns the new items by
+// key. Callbacks run after the lock was released.
+func (table *CacheTable) SetMany(items map[interface{}]interface{}, lifeSpan time.Duration) map[interface{}]*CacheItem {
+	added := make(map[interface{}]*CacheItem, len(items))
+	var replaced []*CacheItem
+	var traces []*trace
+
+	start := time.Now()
+	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return added
+	}
+	for key, data := range items {
+		traces = append(traces, table.startTraceAt(context.Background(), table.tracer, OpSet, key, start))
+		item := table.newItem(key, lifeSpan, data)
+		if old := table.storeInternal(item); old != nil {
+			replaced = append(replaced, old)
+		}
+		added[key] = item
+	}
+	table.stats.evict(ReasonReplaced, len(replaced))
+	addedItem := table.addedItem
+	aboutToDeleteItem := table.aboutToDeleteItem
+	table.Unlock()
+
+	for _, item := range added {
+		for _, callback := range addedItem {
+			callback(item)
+		}
+		table.stats.set()
+	}
+	for _, old := range replaced {
+		table.notifyDelete(aboutToDeleteItem, old, ReasonReplaced)
+	}
+	for _, tr := range traces {
+		tr.end(nil)
+	}
+	for key := range added {
+		table.publishKey(key)
+	}
+	table.trim()
+	return added
+}
+
+// DeleteMany deletes all keys from the cache, taking the table lock only
+// once. It returns the deleted items by key; missing keys are left out.
+// Delete callbacks run after the items were removed.
+func (table *CacheTable) DeleteMany(keys ...interface{}) map[interface{}]*CacheItem {
+	deleted := table.removeMany(ReasonDeleted, func() []interface{} {
+		return keys
+	})
+	for _, key := range keys {
+		// Other replicas may still hold the key even if we don't.
+		if _, ok := deleted[key]; !ok {
+			table.publishKey(key)
+		}
+	}
+	return deleted
+}
+
+// DeleteFunc deletes all items for which pred returns true, and returns them
+// by key. pred runs while the table is locked and must not call back into
+// the table. Delete callbacks run after the items were removed.
+func (table *CacheTable) DeleteFunc(pred func(key interface{}, item *CacheItem) bool) map[interface{}]*CacheItem {
+	return table.removeMany(ReasonDeleted, func() []interface{} {
+		var keys []interface{}
+		for key, item := range table.items {
+			if pred(key, item) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	})
+}
+
+// removeMany removes the items of the keys returned by selectKeys, which
+// runs while the table is locked. Callbacks run after the lock was
+// released.
+func (table *CacheTable) removeMany(reason EvictionReason, selectKeys func() []interface{}) map[interface{}]*CacheItem {
+	var traces []*trace
+
+	start := time.Now()
+	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return map[interface{}]*CacheItem{}
+	}
+	keys := selectKeys()
+	removed := make(map[interface{}]*CacheItem, len(keys))
+	for _, key := range keys {
+		tr := table.startTraceAt(context.Background(), table.tracer, OpDelete, key, start)
+		r, ok := table.items[key]
+		if !ok {
+			tr.end(ErrKeyNotFound)
+			continue
+		}
+		table.log(slog.LevelDebug, "Deleting item", table.keyAttr(key), slog.Any("reason", reason),
+			slog.Time("created", r.createdOn), slog.Int64("hits", r.AccessCount()))
+		table.unshareInternal()
+		delete(table.items, key)
+		table.untagInternal(r)
+		table.unindexInternal(key)
+		table.lru.remove(r)
+		removed[key] = r
+		traces = append(traces, tr)
+	}
+	table.stats.evict(reason, len(removed))
+	aboutToDeleteItem := table.aboutToDeleteItem
+	table.Unlock()
+
+	for _, r := range removed {
+		if reason == ReasonDeleted {
+			table.stats.delete()
+		}
+		table.notifyDelete(aboutToDeleteItem, r, reason)
+		table.publishKey(r.key)
+	}
+	for _, tr := range traces {
+		tr.end(nil)
+	}
+	return removed
+}
@@ -0,0 +1,77 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetMany(t *testing.T) {
+	table := newCacheTable("testSetMany", time.Minute)
+	table.Set("a", 0, "old")
+	var added, replaced []interface{}
+	table.SetAddedItemCallback(func(item *CacheItem) {
+		// the lock is released, so callbacks may use the table
+		if !table.Exists(item.Key()) {
+			t.Error("Added item is missing")
+		}
+		added = append(added, item.Key())
+	})
+	table.SetAboutToDeleteItemWithReasonCallback(func(item *CacheItem, reason EvictionReason) {
+		if reason == ReasonReplaced {
+			replaced = append(replaced, item.Data())
+		}
+	})
+
+	items := table.SetMany(map[interface{}]interface{}{"a": 1, "b": 2}, time.Hour)
+	if len(items) != 2 || items["a"].Data() != 1 || items["b"].LifeSpan() != time.Hour {
+		t.Errorf("Unexpected items %v", items)
+	}
+	if table.Count() != 2 || len(added) != 2 {
+		t.Errorf("Expected 2 items and 2 callbacks, got %d and %d", table.Count(), len(added))
+	}
+	if len(replaced) != 1 || replaced[0] != "old" {
+		t.Errorf("Expected the old item to be replaced, got %v", replaced)
+	}
+	if s := table.Stats().Snapshot(); s.Sets != 3 || s.Evictions[ReasonReplaced] != 1 {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	table := newCacheTable("testDeleteMany", time.Minute)
+	table.SetMany(map[interface{}]interface{}{"a": 1, "b": 2, "c": 3}, 0)
+	var reasons []EvictionReason
+	table.SetAboutToDeleteItemWithReasonCallback(func(item *CacheItem, reason EvictionReason) {
+		reasons = append(reasons, reason)
+	})
+
+	deleted := table.DeleteMany("a", "b", "missing")
+	if len(deleted) != 2 || deleted["a"].Data() != 1 || deleted["b"].Data() != 2 {
+		t.Errorf("Unexpected deleted items %v", deleted)
+	}
+	if table.Count() != 1 || len(reasons) != 2 || reasons[0] != ReasonDeleted {
+		t.Errorf("Unexpected state: %d items, reasons %v", table.Count(), reasons)
+	}
+	if s := table.Stats().Snapshot(); s.Deletes != 2 {
+		t.Errorf("Expected 2 deletes, got %d", s.Deletes)
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	table := newCacheTable("testDeleteFunc", time.Minute)
+	table.SetMany(map[interface{}]interface{}{"user:1": 1, "user:2": 2, "session:1": 3}, 0)
+
+	deleted := table.DeleteFunc(func(key interface{}, item *CacheItem) bool {
+		return strings.HasPrefix(key.(string), "user:")
+	})
+	if len(deleted) != 2 || table.Count() != 1 || !table.Exists("session:1") {
+		t.Errorf("Unexpected deleted items %v", deleted)
+	}
+}
@@ -8,43 +8,147 @@
 package cacher
 
 import (
-	"runtime"
+	"expvar"
+	"sort"
 	"sync"
 	"time"
 )
 
-var (
-	cache = make(map[string]*CacheTable)
-	mutex sync.RWMutex
-)
+// Registry is a set of tables with unique names. The package-level
+// functions use a default registry; separate registries keep the tables of
+// e.g. libraries or tests apart.
+type Registry struct {
+	mu     sync.RWMutex
+	tables map[string]*CacheTable
+	// expvarTables is the published map of all tables, guarded by mu. It
+	// is nil until PublishExpvar gets called.
+	expvarTables *expvar.Map
+}
+
+var defaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{tables: make(map[string]*CacheTable)}
+}
+
+// DefaultRegistry returns the registry used by the package-level functions.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
 
 // New Return a new cache with a given default expiration duration and cleanup
 // interval. If the expiration duration is less than one (or NoExpiration),
 // the items in the cache never expire (by default), and must be deleted
 func New(table string, cleanupInterval time.Duration) *CacheTable {
-	mutex.RLock()
-	t, ok := cache[table]
-	mutex.RUnlock()
+	return defaultRegistry.New(table, cleanupInterval)
+}
+
+// Tables returns the names of all tables in the cache, sorted.
+func Tables() []string {
+	return defaultRegistry.Tables()
+}
+
+// Lookup returns the table with the given name. Unlike New it never creates
+// a table.
+func Lookup(table string) (*CacheTable, bool) {
+	return defaultRegistry.Lookup(table)
+}
+
+// Drop closes the table with the given name and removes it from the cache.
+func Drop(table string) error {
+	return defaultRegistry.Drop(table)
+}
+
+// New returns the table with the given name, creating it with its janitor
+// running every cleanupInterval if it doesn't exist yet.
+func (r *Registry) New(table string, cleanupInterval time.Duration) *CacheTable {
+	r.mu.RLock()
+	t, ok := r.tables[table]
+	r.mu.RUnlock()
 
 	if !ok {
-		mutex.Lock()
-		t, ok = cache[table]
+		r.mu.Lock()
+		t, ok = r.tables[table]
 		// Double check whether the table exists or not.
 		if !ok {
-			t = &CacheTable{
-				name:              table,
-				cleanupInterval:   cleanupInterval,
-				defaultExpiration: time.Millisecond,
-				//defaultExpiration:defaultExpiration, TODO
-				items: make(map[interface{}]*CacheItem),
-			}
+			t = newCacheTable(table, cleanupInterval)
+			t.registry = r
 			runJanitor(t, cleanupInterval)
-			runtime.SetFinalizer(t, stopJanitor)
 
-			cache[table] = t
+			r.tables[table] = t
+			r.publishTable(t)
 		}
-		mutex.Unlock()
+		r.mu.Unlock()
 	}
 	return t
+}
 
+// Tables returns the names of all tables in the registry, sorted.
+func (r *Registry) Tables() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.tables))
+	for name := range r.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the table with the given name. Unlike New it never creates
+// a table.
+func (r *Registry) Lookup(table string) (*CacheTable, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tables[table]
+	return t, ok
+}
+
+// Drop closes the table with the given name and removes it from the
+// registry.
+func (r *Registry) Drop(table string) error {
+	t, ok := r.Lookup(table)
+	if !ok {
+		return ErrTableNotFound
+	}
+	return t.Close()
+}
+
+// CloseAll closes all tables of the registry, leaving it empty.
+func (r *Registry) CloseAll() {
+	r.mu.RLock()
+	tables := make([]*CacheTable, 0, len(r.tables))
+	for _, t := range r.tables {
+		tables = append(tables, t)
+	}
+	r.mu.RUnlock()
+
+	for _, t := range tables {
+		t.Close()
+	}
+}
+
+// unregister removes t from the registry, unless the name belongs to
+// another table by now.
+func (r *Registry) unregister(t *CacheTable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tables[t.name] == t {
+		delete(r.tables, t.name)
+		r.unpublishTable(t)
+	}
+}
+
+// newCacheTable returns an empty table which is not registered in a registry
+// and has no janitor running yet.
+func newCacheTable(table string, cleanupInterval time.Duration) *CacheTable {
+	return &CacheTable{
+		name:            table,
+		cleanupInterval: cleanupInterval,
+		clock:           SystemClock{},
+		items:           make(map[interface{}]*CacheItem),
+		stats:           new(Stats),
+	}
 }
@@ -25,13 +25,21 @@ type CacheItem struct {
 
 	// Creation timestamp.
 	createdOn time.Time
+	// When the item expires, zero if it doesn't.
+	expiresAt time.Time
 	// Last access timestamp.
 	accessedOn time.Time
 	// How often the item was accessed.
 	accessCount int64
-
-	// Callback method triggered right before removing the item from the cache
-	aboutToExpire []func(key interface{})
+	// Source of the timestamps.
+	clock Clock
+	// Version of the data, assigned by the table. 0 until it is stored.
+	version uint64
+	// Tags for group invalidation.
+	tags []string
+
+	// Callback method triggered when the item got removed from the cache
+	aboutToExpire []func(key interface{}, reason EvictionReason)
 }
 
 // NewCacheItem returns a newly created CacheItem.
@@ -40,32 +48,71 @@ type CacheItem struct {
 // will get removed from the cache.
 // Parameter data is the item's value.
 func NewCacheItem(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
-	t := time.Now()
+	return newCacheItem(SystemClock{}, key, lifeSpan, data)
+}
+
+func newCacheItem(clock Clock, key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	t := clock.Now()
 	return &CacheItem{
 		key:           key,
 		lifeSpan:      lifeSpan,
 		createdOn:     t,
+		expiresAt:     expiry(t, lifeSpan),
 		accessedOn:    t,
 		accessCount:   0,
+		clock:         clock,
 		aboutToExpire: nil,
 		data:          data,
 	}
 }
 
+func expiry(from time.Time, lifeSpan time.Duration) time.Time {
+	if lifeSpan <= 0 {
+		return time.Time{}
+	}
+	return from.Add(lifeSpan)
+}
+
 // KeepAlive marks an item to be kept for another expireDuration period.
 func (item *CacheItem) KeepAlive() {
 	item.Lock()
 	defer item.Unlock()
-	item.accessedOn = time.Now()
+	item.accessedOn = item.clock.Now()
+	item.accessCount++
+	if item.lifeSpan > 0 {
+		item.expiresAt = expiry(item.accessedOn, item.lifeSpan)
+	}
+}
+
+// access updates the access counter and timestamp, leaving the expiry.
+func (item *CacheItem) access() {
+	item.Lock()
+	defer item.Unlock()
+	item.accessedOn = item.clock.Now()
 	item.accessCount++
 }
 
 // LifeSpan returns this item's expiration duration.
 func (item *CacheItem) LifeSpan() time.Duration {
-	// immutable
+	item.RLock()
+	defer item.RUnlock()
 	return item.lifeSpan
 }
 
+// expired reports whether the item's lifespan elapsed at now.
+func (item *CacheItem) expired(now time.Time) bool {
+	item.RLock()
+	defer item.RUnlock()
+	return !item.expiresAt.IsZero() && !now.Before(item.expiresAt)
+}
+
+// ExpiresAt returns when this item expires, or the zero time if it doesn't.
+func (item *CacheItem) ExpiresAt() time.Time {
+	item.RLock()
+	defer item.RUnlock()
+	return item.expiresAt
+}
+
 // AccessedOn returns when this item was last accessed.
 func (item *CacheItem) AccessedOn() time.Time {
 	item.RLock()
@@ -86,6 +133,21 @@ func (item *CacheItem) AccessCount() int64 {
 	return item.accessCount
 }
 
+// Version returns the version of this item's data. Every change of a key's
+// data gets a higher version within the table. It is 0 for items which were
+// never stored.
+func (item *CacheItem) Version() uint64 {
+	item.RLock()
+	defer item.RUnlock()
+	return item.version
+}
+
+// Tags returns the tags of this item.
+func (item *CacheItem) Tags() []string {
+	// immutable
+	return append([]string(nil), item.tags...)
+}
+
 // Key returns the key of this cached item.
 func (item *CacheItem) Key() interface{} {
 	// immutable
@@ -94,23 +156,34 @@ func (item *CacheItem) Key() interface{} {
 
 // Data returns the value of this cached item.
 func (item *CacheItem) Data() interface{} {
-	// immutable
+	// numeric data gets changed in place by Increment
+	item.RLock()
+	defer item.RUnlock()
 	return item.data
 }
 
-// SetAboutToExpireCallback configures a callback, which will be called right
-// before the item is about to be removed from the cache.
+// SetAboutToExpireCallback configures a callback, which will be called when
+// the item got removed from the cache.
 func (item *CacheItem) SetAboutToExpireCallback(f func(interface{})) {
-	if len(item.aboutToExpire) > 0 {
-		item.RemoveAboutToExpireCallback()
-	}
-	item.Lock()
-	defer item.Unlock()
-	item.aboutToExpire = append(item.aboutToExpire, f)
+	item.SetAboutToExpireWithReasonCallback(ignoreReason(f))
 }
 
 // AddAboutToExpireCallback appends a new callback to the AboutToExpire queue
 func (item *CacheItem) AddAboutToExpireCallback(f func(interface{})) {
+	item.AddAboutToExpireWithReasonCallback(ignoreReason(f))
+}
+
+// SetAboutToExpireWithReasonCallback configures a callback, which will be
+// called when the item gets removed from the cache, along with the reason.
+func (item *CacheItem) SetAboutToExpireWithReasonCallback(f func(key interface{}, reason EvictionReason)) {
+	item.Lock()
+	defer item.Unlock()
+	item.aboutToExpire = []func(interface{}, EvictionReason){f}
+}
+
+// AddAboutToExpireWithReasonCallback appends a new callback receiving the
+// reason to the AboutToExpire queue
+func (item *CacheItem) AddAboutToExpireWithReasonCallback(f func(key interface{}, reason EvictionReason)) {
 	item.Lock()
 	defer item.Unlock()
 	item.aboutToExpire = append(item.aboutToExpire, f)
@@ -122,3 +195,9 @@ func (item *CacheItem) RemoveAboutToExpireCallback() {
 	defer item.Unlock()
 	item.aboutToExpire = nil
 }
+
+func ignoreReason(f func(interface{})) func(interface{}, EvictionReason) {
+	return func(key interface{}, _ EvictionReason) {
+		f(key)
+	}
+}
@@ -7,10 +7,13 @@
 package cacher
 
 import (
+	"context"
 	"github.com/SmallSmartMouse/cacher/singleflight"
 	"log"
+	"log/slog"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,15 +23,31 @@ type CacheTable struct {
 	singleSetCache singleflight.Group
 	// The table's name.
 	name string
+	// The registry the table belongs to, if any.
+	registry *Registry
 	// All cached items.
 	items map[interface{}]*CacheItem
+	// true items is referenced by a Snapshot and must be copied before
+	// changing it
+	itemsShared bool
+	// true the table was closed
+	closed bool
+	// Hit, miss, load and eviction counters.
+	stats *Stats
 
 	// Current timer duration.
 	cleanupInterval time.Duration
 	// default expire duration.
 	defaultExpiration time.Duration
-	// The logger used for this table.
-	logger *log.Logger
+	// Source of item timestamps and janitor ticks.
+	clock Clock
+	// Maximum number of items, 0 if unlimited.
+	capacity int
+	// Items by last access if the table has a capacity, nil otherwise.
+	lru *lru
+	// The logger used for this table. It is read without holding the table
+	// lock, as logging happens with and without it.
+	logger atomic.Pointer[tableLogger]
 	// true cache empty data
 	enableNullData bool
 	enableAutoLoad bool
@@ -37,8 +56,56 @@ type CacheTable struct {
 	loadData func(k interface{}) (interface{}, time.Duration, error)
 	// Callback method triggered when adding a new item to the cache.
 	addedItem []func(item *CacheItem)
-	// Callback method triggered before deleting an item from the cache.
-	aboutToDeleteItem []func(item *CacheItem)
+	// Callback method triggered when an item got removed from the cache.
+	aboutToDeleteItem []func(item *CacheItem, reason EvictionReason)
+	// Connection to the other replicas of this table, if any.
+	invalidation *invalidation
+	// true publish flushes to the other replicas
+	broadcastFlush bool
+	// Tracer notified about operations on this table, if any.
+	tracer Tracer
+	// Event subscribers. The list is replaced on change, so it can be
+	// read without holding a lock; subscriptionsMu serializes writers.
+	subscriptionList atomic.Pointer[[]*subscription]
+	subscriptionsMu  sync.Mutex
+	// Last version handed out to an item, accessed atomically.
+	version uint64
+	// Keys by tag, for the items which have tags.
+	tagIndex map[string]map[interface{}]struct{}
+	// String keys in order, if enabled.
+	ordered *skiplist
+	// Number of the table's items in the dependency graph, accessed
+	// atomically.
+	depNodes int64
+	// Serialize Compute calls on the same key.
+	keyLocks [keyLockStripes]sync.Mutex
+}
+
+// Name returns the name of the table.
+func (table *CacheTable) Name() string {
+	// immutable
+	return table.name
+}
+
+// CleanupInterval returns the interval of the table's expiration checks.
+func (table *CacheTable) CleanupInterval() time.Duration {
+	table.RLock()
+	defer table.RUnlock()
+	return table.cleanupInterval
+}
+
+// NullDataEnabled returns whether failed loads are cached as nil data.
+func (table *CacheTable) NullDataEnabled() bool {
+	table.RLock()
+	defer table.RUnlock()
+	return table.enableNullData
+}
+
+// DataLoaderEnabled returns whether a data-loader callback is configured.
+func (table *CacheTable) DataLoaderEnabled() bool {
+	table.RLock()
+	defer table.RUnlock()
+	return table.loadData != nil
 }
 
 // Count returns how many items are currently stored in the cache.
@@ -48,14 +115,13 @@ func (table *CacheTable) Count() int {
 	return len(table.items)
 }
 
-// Foreach all items
+// Foreach all items. It iterates over a copy, so trans may change the
+// table.
 func (table *CacheTable) Foreach(trans func(key interface{}, item *CacheItem)) {
-	table.RLock()
-	defer table.RUnlock()
-
-	for k, v := range table.items {
+	table.Iterate(func(k interface{}, v *CacheItem) bool {
 		trans(k, v)
-	}
+		return true
+	})
 }
 
 func (table *CacheTable) EnableNullData(b bool) {
@@ -100,18 +166,29 @@ func (table *CacheTable) RemoveAddedItemCallbacks() {
 }
 
 // SetAboutToDeleteItemCallback configures a callback, which will be called
-// every time an item is about to be removed from the cache.
+// every time an item got removed from the cache.
 func (table *CacheTable) SetAboutToDeleteItemCallback(f func(*CacheItem)) {
-	if len(table.aboutToDeleteItem) > 0 {
-		table.RemoveAboutToDeleteItemCallback()
-	}
-	table.Lock()
-	defer table.Unlock()
-	table.aboutToDeleteItem = append(table.aboutToDeleteItem, f)
+	table.SetAboutToDeleteItemWithReasonCallback(ignoreItemReason(f))
 }
 
 // AddAboutToDeleteItemCallback appends a new callback to the AboutToDeleteItem queue
 func (table *CacheTable) AddAboutToDeleteItemCallback(f func(*CacheItem)) {
+	table.AddAboutToDeleteItemWithReasonCallback(ignoreItemReason(f))
+}
+
+// SetAboutToDeleteItemWithReasonCallback configures a callback, which will be
+// called every time an item leaves the cache, along with the reason.
+// Whatever the reason, the item is already gone from the table when it is
+// called.
+func (table *CacheTable) SetAboutToDeleteItemWithReasonCallback(f func(item *CacheItem, reason EvictionReason)) {
+	table.Lock()
+	defer table.Unlock()
+	table.aboutToDeleteItem = []func(*CacheItem, EvictionReason){f}
+}
+
+// AddAboutToDeleteItemWithReasonCallback appends a new callback receiving the
+// reason to the AboutToDeleteItem queue
+func (table *CacheTable) AddAboutToDeleteItemWithReasonCallback(f func(item *CacheItem, reason EvictionReason)) {
 	table.Lock()
 	defer table.Unlock()
 	table.aboutToDeleteItem = append(table.aboutToDeleteItem, f)
@@ -124,59 +201,88 @@ func (table *CacheTable) RemoveAboutToDeleteItemCallback() {
 	table.aboutToDeleteItem = nil
 }
 
-// SetLogger sets the logger to be used by this cache table.
+func ignoreItemReason(f func(*CacheItem)) func(*CacheItem, EvictionReason) {
+	return func(item *CacheItem, _ EvictionReason) {
+		f(item)
+	}
+}
+
+// SetLogger sets the logger to be used by this cache table. Every message is
+// written as a single line, followed by its attributes as key=value pairs.
+// It replaces a logger set by SetStructuredLogger.
 func (table *CacheTable) SetLogger(logger *log.Logger) {
-	table.Lock()
-	defer table.Unlock()
-	table.logger = logger
+	table.updateLogger(func(l *tableLogger) {
+		l.legacy, l.slog = logger, nil
+	})
 }
 
 // ExpirationCheck check loop
 func (table *CacheTable) ExpirationCheck() {
+	start := time.Now()
+	defer func() { table.stats.janitor(time.Since(start)) }()
 	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return
+	}
+	tr := table.startTrace(context.Background(), table.tracer, OpJanitor, nil)
+	tr.items(len(table.items))
 
 	if table.cleanupInterval > 0 {
-		table.log("Expiration check triggered after", table.cleanupInterval, "for table", table.name)
+		table.log(slog.LevelDebug, "Expiration check triggered", slog.Duration("interval", table.cleanupInterval))
 	} else {
-		table.log("Expiration check installed for table", table.name)
+		table.log(slog.LevelDebug, "Expiration check installed")
 	}
 
 	// To be more accurate with timers, we would need to update 'now' on every
 	// loop iteration. Not sure it's really efficient though.
-	now := time.Now()
-	for key, item := range table.items {
-		// Cache values so we don't keep blocking the mutex.
-		item.RLock()
-		lifeSpan := item.lifeSpan
-		accessedOn := item.accessedOn
-		createdOn := item.createdOn
-		item.RUnlock()
-		// lasting key
-		if lifeSpan == 0 {
-			continue
+	now := table.clock.Now()
+	// Collect first, as deleting unlocks the table in between.
+	var expired []*CacheItem
+	for _, item := range table.items {
+		if item.expired(now) {
+			expired = append(expired, item)
 		}
+	}
 
-		if now.Sub(createdOn) >= lifeSpan {
-			if table.enableAutoLoad {
-				if now.Sub(accessedOn) <= lifeSpan*2/3 {
-					temp, tempLifeSpan, err1 := table.loadData(key)
-					if err1 == nil {
-						table.addInternal(NewCacheItem(key, tempLifeSpan, temp))
-						continue
-					}
+	for _, item := range expired {
+		key := item.key
+		if table.items[key] != item || !item.expired(now) {
+			// Changed while the table was unlocked.
+			continue
+		}
+		table.stats.expire()
+		reason := ReasonExpired
+		if table.enableAutoLoad {
+			if now.Sub(item.AccessedOn()) <= item.LifeSpan()*2/3 {
+				ltr := table.startTrace(tr.context(context.Background()), table.tracer, OpLoad, key)
+				start := time.Now()
+				temp, tempLifeSpan, err1 := table.loadData(key)
+				table.stats.load(time.Since(start), err1)
+				ltr.end(err1)
+				if err1 == nil {
+					table.stats.evict(ReasonReplaced, 1)
+					table.addInternal(table.newLoadedItem(key, tempLifeSpan, temp))
+					aboutToDeleteItem := table.aboutToDeleteItem
+					table.Unlock()
+					table.notifyDelete(aboutToDeleteItem, item, ReasonReplaced)
+					table.Lock()
+					continue
 				}
+				table.log(slog.LevelWarn, "Reloading expired item failed", table.keyAttr(key), slog.Any("error", err1))
+				reason = ReasonLoadFailed
 			}
-			table.deleteInternal(key)
 		}
+		table.deleteInternal(key, reason)
 	}
 	table.Unlock()
+	tr.end(nil)
 }
 
 func (table *CacheTable) addInternal(item *CacheItem) {
 	// Careful: do not run this method unless the table-mutex is locked!
 	// It will unlock it for the caller before running the callbacks and checks
-	table.log("Adding item with key", item.key, "and lifespan of", item.lifeSpan, "to table", table.name)
-	table.items[item.key] = item
+	table.storeInternal(item)
 
 	// Cache values so we don't keep blocking the mutex.
 	addedItem := table.addedItem
@@ -188,60 +294,135 @@ func (table *CacheTable) addInternal(item *CacheItem) {
 	}
 }
 
+// storeInternal puts item into the table without running the added item
+// callbacks, and returns the item it replaced, if any. Do not run it unless
+// the table-mutex is locked.
+func (table *CacheTable) storeInternal(item *CacheItem) *CacheItem {
+	item.Lock()
+	item.version = table.nextVersion()
+	if item.lifeSpan == 0 && table.defaultExpiration > 0 {
+		item.lifeSpan = table.defaultExpiration
+		item.expiresAt = expiry(item.createdOn, item.lifeSpan)
+	}
+	item.Unlock()
+	table.log(slog.LevelDebug, "Adding item", table.keyAttr(item.key), slog.Duration("lifespan", item.lifeSpan))
+	old, exists := table.items[item.key]
+	table.unshareInternal()
+	table.items[item.key] = item
+	if exists {
+		table.untagInternal(old)
+		table.lru.remove(old)
+	} else {
+		table.indexInternal(item.key)
+	}
+	table.tagInternal(item)
+	table.lru.push(item)
+	if exists {
+		table.emit(EventUpdate, item, 0)
+	} else {
+		table.emit(EventAdd, item, 0)
+	}
+	return old
+}
+
 // Set adds a key/value pair to the cache.
 // Parameter key is the item's cache-key.
 // Parameter lifeSpan determines after which time period without an access the item
-// will get removed from the cache.
+// will get removed from the cache. 0 means the table's default TTL, if any.
 // Parameter data is the item's value.
 func (table *CacheTable) Set(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
-	item := NewCacheItem(key, lifeSpan, data)
+	item := table.newItem(key, lifeSpan, data)
 
 	// Set item to cache.
+	table.setInternal(item, nil)
+	return item
+}
+
+// setInternal stores item, replacing the item of the same key. If check is
+// given, it can veto the change by returning an error, seeing the current
+// item or nil.
+func (table *CacheTable) setInternal(item *CacheItem, check func(old *CacheItem) error) error {
+	start := time.Now()
 	table.Lock()
+	tr := table.startTraceAt(context.Background(), table.tracer, OpSet, item.key, start)
+	if table.closed {
+		table.Unlock()
+		tr.end(ErrTableClosed)
+		return ErrTableClosed
+	}
+	old, replaced := table.items[item.key]
+	if check != nil {
+		if err := check(old); err != nil {
+			table.Unlock()
+			tr.end(err)
+			return err
+		}
+	}
+	if replaced {
+		table.stats.evict(ReasonReplaced, 1)
+	}
 	table.addInternal(item)
+	aboutToDeleteItem := table.aboutToDeleteItem
 	table.Unlock()
+	if replaced {
+		table.notifyDelete(aboutToDeleteItem, old, ReasonReplaced)
+	}
+	table.stats.set()
+	tr.end(nil)
 
-	return item
+	table.publishKey(item.key)
+	table.trim()
+	return nil
 }
 
-func (table *CacheTable) deleteInternal(key interface{}) (*CacheItem, error) {
+func (table *CacheTable) deleteInternal(key interface{}, reason EvictionReason) (*CacheItem, error) {
+	if table.closed {
+		return nil, ErrTableClosed
+	}
 	r, ok := table.items[key]
 	if !ok {
 		return nil, ErrKeyNotFound
 	}
+	table.stats.evict(reason, 1)
+	tr := table.startTrace(context.Background(), table.tracer, OpEvict, key)
+	tr.reason(reason)
+
+	table.log(slog.LevelDebug, "Deleting item", table.keyAttr(key), slog.Any("reason", reason),
+		slog.Time("created", r.createdOn), slog.Int64("hits", r.AccessCount()))
+	table.unshareInternal()
+	delete(table.items, key)
+	table.untagInternal(r)
+	table.unindexInternal(key)
+	table.lru.remove(r)
 
 	// Cache value so we don't keep blocking the mutex.
 	aboutToDeleteItem := table.aboutToDeleteItem
 	table.Unlock()
 
-	// Trigger callbacks before deleting an item from cache.
-	if aboutToDeleteItem != nil {
-		for _, callback := range aboutToDeleteItem {
-			callback(r)
-		}
-	}
-
-	r.RLock()
-	defer r.RUnlock()
-	if r.aboutToExpire != nil {
-		for _, callback := range r.aboutToExpire {
-			callback(key)
-		}
-	}
+	// Trigger callbacks once the item is gone, like for every other reason.
+	table.notifyDelete(aboutToDeleteItem, r, reason)
 
 	table.Lock()
-	table.log("Deleting item with key", key, "created on", r.createdOn, "and hit", r.accessCount, "times from table", table.name)
-	delete(table.items, key)
+	tr.end(nil)
 
 	return r, nil
 }
 
 // Delete an item from the cache.
 func (table *CacheTable) Delete(key interface{}) (*CacheItem, error) {
+	start := time.Now()
 	table.Lock()
-	defer table.Unlock()
+	tr := table.startTraceAt(context.Background(), table.tracer, OpDelete, key, start)
+	r, err := table.deleteInternal(key, ReasonDeleted)
+	table.Unlock()
+	if err == nil {
+		table.stats.delete()
+	}
+	tr.end(err)
 
-	return table.deleteInternal(key)
+	// Other replicas may still hold the key even if we don't.
+	table.publishKey(key)
+	return r, err
 }
 
 // Exists returns whether an item exists in the cache. Unlike the Get method
@@ -258,46 +439,71 @@ func (table *CacheTable) Exists(key interface{}) bool {
 // Add checks whether an item is not yet cached. Unlike the Exists
 // method this also adds data if the key could not be found.
 func (table *CacheTable) Add(key interface{}, lifeSpan time.Duration, data interface{}) bool {
-	table.Lock()
-	if _, ok := table.items[key]; ok {
-		table.Unlock()
-		return false
-	}
-
-	item := NewCacheItem(key, lifeSpan, data)
-	table.addInternal(item)
-	table.Unlock()
-	return true
+	item, loaded := table.getOrSet(key, lifeSpan, data, false)
+	return item != nil && !loaded
 }
 
 // Get returns an item from the cache and marks it to be kept alive. You can
 // pass additional arguments to your DataLoader callback function.
 func (table *CacheTable) Get(key interface{}) (*CacheItem, error) {
+	return table.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get. The context is handed to the table's Tracer.
+func (table *CacheTable) GetContext(ctx context.Context, key interface{}) (item *CacheItem, err error) {
+	start := time.Now()
 	table.RLock()
 	r, ok := table.items[key]
 	loadData := table.loadData
+	tracer := table.tracer
+	closed := table.closed
 	table.RUnlock()
+	if closed {
+		return nil, ErrTableClosed
+	}
+
+	tr := table.startTraceAt(ctx, tracer, OpGet, key, start)
+	defer func() { tr.end(err) }()
 
 	if ok {
 		// Update access counter and timestamp.
-		r.KeepAlive()
+		table.stats.hit()
+		tr.hit()
+		r.access()
+		table.lru.touch(r)
 		return r, nil
 	}
+	table.stats.miss()
 
 	// Item doesn't exist in cache. Try and fetch it with a data-loader.
 	if loadData != nil {
-		data, err, _ := table.singleSetCache.Do(key, func() (interface{}, error) {
+		ltr := table.startTrace(tr.context(ctx), tracer, OpLoad, key)
+		leader := false
+		data, err, shared := table.singleSetCache.Do(key, func() (interface{}, error) {
+			leader = true
+			start := time.Now()
 			temp, tempLifeSpan, err1 := loadData(key)
-			if err1 != nil && !table.enableNullData {
-				return nil, err1
+			table.stats.load(time.Since(start), err1)
+			if err1 != nil {
+				table.log(slog.LevelWarn, "Loading item failed", table.keyAttr(key), slog.Any("error", err1))
+				if !table.enableNullData {
+					return nil, err1
+				}
 			}
 
-			item := NewCacheItem(key, tempLifeSpan, temp)
+			item := table.newLoadedItem(key, tempLifeSpan, temp)
 			table.Lock()
+			if table.closed {
+				table.Unlock()
+				return nil, ErrTableClosed
+			}
 			table.addInternal(item)
 			table.Unlock()
+			table.trim()
 			return item, nil
 		})
+		ltr.shared(shared && !leader)
+		ltr.end(err)
 
 		if err != nil {
 			return nil, err
@@ -309,13 +515,46 @@ func (table *CacheTable) Get(key interface{}) (*CacheItem, error) {
 
 // Flush deletes all items from this cache table.
 func (table *CacheTable) Flush() {
-	table.Lock()
-	defer table.Unlock()
+	table.flush()
+	table.publishFlush()
+}
 
-	table.log("Flushing table", table.name)
+func (table *CacheTable) flush() {
+	table.Lock()
+	table.log(slog.LevelDebug, "Flushing table", slog.Int("items", len(table.items)))
 
+	items := table.items
+	table.stats.evict(ReasonFlushed, len(items))
 	table.items = make(map[interface{}]*CacheItem)
-	table.cleanupInterval = 0
+	table.itemsShared = false
+	table.tagIndex = nil
+	if table.ordered != nil {
+		table.ordered = newSkiplist()
+	}
+	table.lru.reset()
+	aboutToDeleteItem := table.aboutToDeleteItem
+	table.Unlock()
+
+	for _, item := range items {
+		table.notifyDelete(aboutToDeleteItem, item, ReasonFlushed)
+	}
+}
+
+// notifyDelete triggers the table's and the item's callbacks and the event
+// for an item leaving the table. Do not run it while holding the table-mutex.
+func (table *CacheTable) notifyDelete(aboutToDeleteItem []func(*CacheItem, EvictionReason), item *CacheItem, reason EvictionReason) {
+	table.emitRemoval(item, reason)
+	table.cascade(item.key)
+	for _, callback := range aboutToDeleteItem {
+		callback(item, reason)
+	}
+
+	item.RLock()
+	aboutToExpire := item.aboutToExpire
+	item.RUnlock()
+	for _, callback := range aboutToExpire {
+		callback(item.key, reason)
+	}
 }
 
 // CacheItemPair maps key to access counter
@@ -362,42 +601,14 @@ func (table *CacheTable) MostAccessed(count int64) []*CacheItem {
 	return r
 }
 
-// Internal logging method for convenience.
-func (table *CacheTable) log(v ...interface{}) {
-	if table.logger == nil {
-		return
-	}
-
-	table.logger.Println(v...)
-}
-
 type janitor struct {
 	Interval time.Duration
-	stop     chan bool
-}
-
-func (j *janitor) Run(c *CacheTable) {
-	ticker := time.NewTicker(j.Interval)
-	for {
-		select {
-		case <-ticker.C:
-			c.ExpirationCheck()
-		case <-j.stop:
-			ticker.Stop()
-			return
-		}
-	}
-}
-
-func stopJanitor(c *CacheTable) {
-	c.janitor.stop <- true
+	stop     func()
 }
 
 func runJanitor(c *CacheTable, ci time.Duration) {
-	j := &janitor{
+	c.janitor = &janitor{
 		Interval: ci,
-		stop:     make(chan bool),
+		stop:     c.clock.Tick(ci, c.ExpirationCheck),
 	}
-	c.janitor = j
-	go j.Run(c)
 }
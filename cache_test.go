@@ -11,11 +11,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"reflect"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/SmallSmartMouse/cacher/cachertest"
 )
 
 var (
@@ -56,20 +59,26 @@ func TestNew(t *testing.T) {
 }
 
 func TestCacheExpire(t *testing.T) {
-	table := New("testCacheE", time.Millisecond)
+	clock := cachertest.NewFakeClock(time.Now())
+	table, err := NewRegistry().NewWithOptions("testCacheE",
+		WithCleanupInterval(time.Millisecond), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
 
 	table.Set(k+"_1", 10*time.Millisecond, v+"_1")
 	table.Set(k+"_2", 30*time.Millisecond, v+"_2")
 
-	time.Sleep(5 * time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
 
 	// check key `1` is still alive
-	_, err := table.Get(k + "_1")
+	_, err = table.Get(k + "_1")
 	if err != nil {
 		t.Error("Error retrieving value from cache:", err)
 	}
 
-	time.Sleep(20 * time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
 
 	// check key `1` again, it should still be alive since we just accessed it
 	_, err = table.Get(k + "_1")
@@ -149,21 +158,27 @@ func TestNotFoundAddConcurrency(t *testing.T) {
 
 func TestCacheKeepAlive(t *testing.T) {
 	// add an expiring item
-	table := New("testKeepAlive", time.Millisecond)
+	clock := cachertest.NewFakeClock(time.Now())
+	table, err := NewRegistry().NewWithOptions("testKeepAlive",
+		WithCleanupInterval(time.Millisecond), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
 	p := table.Set(k, 250*time.Millisecond, v)
 
 	// keep it alive before it expires
-	time.Sleep(100 * time.Millisecond)
+	clock.Advance(100 * time.Millisecond)
 	p.KeepAlive()
 
 	// check it's still alive after it was initially supposed to expire
-	time.Sleep(150 * time.Millisecond)
+	clock.Advance(150 * time.Millisecond)
 	if !table.Exists(k) {
 		t.Error("Error keeping item alive")
 	}
 
 	// check it expires eventually
-	time.Sleep(300 * time.Millisecond)
+	clock.Advance(100 * time.Millisecond)
 	if table.Exists(k) {
 		t.Error("Error expiring item after keeping it alive")
 	}
@@ -396,7 +411,12 @@ func TestCallbackQueue(t *testing.T) {
 	expired := false
 	calledExpired := false
 	// setup a cache with AddedItem & SetAboutToDelete handlers configured
-	table := New("testCallbacks", time.Second)
+	clock := cachertest.NewFakeClock(time.Now())
+	table, err := NewRegistry().NewWithOptions("testCallbacks", WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
 
 	// test callback queue
 	table.AddAddedItemCallback(func(item *CacheItem) {
@@ -433,14 +453,14 @@ func TestCallbackQueue(t *testing.T) {
 		m.Unlock()
 	})
 
-	time.Sleep(250 * time.Millisecond)
+	clock.Advance(250 * time.Millisecond)
 	m.Lock()
 	if addedKey != k && addedkeyCallback2 != secondCallbackResult {
 		t.Error("AddedItem callback queue not working")
 	}
 	m.Unlock()
 
-	time.Sleep(500 * time.Millisecond)
+	clock.Advance(time.Second)
 	m.Lock()
 	if removedKey != k && removedKeyCallback != secondCallbackResult {
 		t.Error("Item removed callback queue not working")
@@ -461,7 +481,7 @@ func TestCallbackQueue(t *testing.T) {
 	i.RemoveAboutToExpireCallback()
 
 	// verify if the callbacks were removed
-	time.Sleep(250 * time.Millisecond)
+	clock.Advance(250 * time.Millisecond)
 	m.Lock()
 	if addedKey == secondItemKey {
 		t.Error("AddedItemCallbacks were not removed")
@@ -469,7 +489,7 @@ func TestCallbackQueue(t *testing.T) {
 	m.Unlock()
 
 	// verify the AboutToDelete handler works
-	time.Sleep(500 * time.Millisecond)
+	clock.Advance(time.Second)
 	m.Lock()
 	if removedKey == secondItemKey {
 		t.Error("AboutToDeleteItem not removed")
@@ -481,6 +501,53 @@ func TestCallbackQueue(t *testing.T) {
 	m.Unlock()
 }
 
+func TestCallbackReasons(t *testing.T) {
+	var m sync.Mutex
+	reasons := make(map[string][]EvictionReason)
+	var expired []EvictionReason
+	table := New("testCallbackReasons", time.Minute)
+	table.SetAboutToDeleteItemWithReasonCallback(func(item *CacheItem, reason EvictionReason) {
+		m.Lock()
+		defer m.Unlock()
+		key := item.Key().(string)
+		reasons[key] = append(reasons[key], reason)
+		if r, err := table.Peek(key); err == nil && r == item {
+			t.Errorf("%s was still in the table for reason %v", key, reason)
+		}
+	})
+
+	table.Set("deleted", 0, v)
+	table.Delete("deleted")
+	i := table.Set("replaced", 0, v)
+	i.AddAboutToExpireWithReasonCallback(func(key interface{}, reason EvictionReason) {
+		m.Lock()
+		defer m.Unlock()
+		expired = append(expired, reason)
+	})
+	table.Set("replaced", 0, v)
+	table.Set("expired", time.Nanosecond, v)
+	time.Sleep(time.Millisecond)
+	table.ExpirationCheck()
+	table.Set("flushed", 0, v)
+	table.Flush()
+
+	m.Lock()
+	defer m.Unlock()
+	for key, want := range map[string][]EvictionReason{
+		"deleted":  {ReasonDeleted},
+		"replaced": {ReasonReplaced, ReasonFlushed},
+		"expired":  {ReasonExpired},
+		"flushed":  {ReasonFlushed},
+	} {
+		if !reflect.DeepEqual(reasons[key], want) {
+			t.Errorf("Expected reasons %v for %s, got %v", want, key, reasons[key])
+		}
+	}
+	if !reflect.DeepEqual(expired, []EvictionReason{ReasonReplaced}) {
+		t.Errorf("Expected item callback for the replacement, got %v", expired)
+	}
+}
+
 func TestLogger(t *testing.T) {
 	// setup a logger
 	out := new(bytes.Buffer)
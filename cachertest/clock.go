@@ -0,0 +1,83 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+// Package cachertest provides helpers for testing code using cacher.
+package cachertest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a cacher.Clock which only moves when Advance gets called.
+// Tickers fire synchronously from Advance, so after it returns, the
+// expiration checks that became due have run.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*ticker
+}
+
+type ticker struct {
+	next     time.Time
+	interval time.Duration
+	f        func()
+}
+
+// NewFakeClock returns a clock standing at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Tick calls f every d of time the clock gets advanced by.
+func (c *FakeClock) Tick(d time.Duration, f func()) (stop func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &ticker{next: c.now.Add(d), interval: d, f: f}
+	c.tickers = append(c.tickers, t)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, other := range c.tickers {
+			if other == t {
+				c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Advance moves the clock forward by d. Every tick which becomes due fires
+// in order, with the clock set to its time, before Advance returns.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		var due *ticker
+		for _, t := range c.tickers {
+			if !t.next.After(end) && (due == nil || t.next.Before(due.next)) {
+				due = t
+			}
+		}
+		if due == nil {
+			break
+		}
+		c.now = due.next
+		due.next = due.next.Add(due.interval)
+		c.mu.Unlock()
+		due.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
@@ -0,0 +1,65 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cachertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+func TestFakeClockTick(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	var ticks []time.Time
+	stop := clock.Tick(time.Second, func() {
+		ticks = append(ticks, clock.Now())
+	})
+
+	clock.Advance(500 * time.Millisecond)
+	if len(ticks) != 0 {
+		t.Error("Ticked early")
+	}
+	clock.Advance(2 * time.Second)
+	if len(ticks) != 2 || !ticks[0].Equal(start.Add(time.Second)) || !ticks[1].Equal(start.Add(2*time.Second)) {
+		t.Errorf("Unexpected ticks %v", ticks)
+	}
+	if !clock.Now().Equal(start.Add(2500 * time.Millisecond)) {
+		t.Errorf("Unexpected time %v", clock.Now())
+	}
+
+	stop()
+	clock.Advance(time.Hour)
+	if len(ticks) != 2 {
+		t.Error("Stopped ticker fired")
+	}
+}
+
+func TestFakeClockExpiration(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	r := cacher.NewRegistry()
+	defer r.CloseAll()
+	table, err := r.NewWithOptions("testFakeClock",
+		cacher.WithCleanupInterval(time.Second), cacher.WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := table.Set("a", time.Minute, "data")
+	if !item.CreatedOn().Equal(clock.Now()) {
+		t.Error("Item wasn't stamped by the fake clock")
+	}
+	clock.Advance(30 * time.Second)
+	if ttl, _ := table.TTL("a"); ttl != 30*time.Second {
+		t.Errorf("Expected 30s left, got %v", ttl)
+	}
+	clock.Advance(30 * time.Second)
+	if table.Exists("a") {
+		t.Error("Item didn't expire when the clock advanced")
+	}
+}
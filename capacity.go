@@ -0,0 +1,126 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// lru orders the items of a table with a capacity by their last access,
+// most recent first. It has its own mutex, so Get can update it without
+// taking the table lock for writing. When both are needed, the table lock
+// is taken first. All methods are no-ops on a nil lru.
+type lru struct {
+	sync.Mutex
+	order list.List
+	elems map[*CacheItem]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{elems: make(map[*CacheItem]*list.Element)}
+}
+
+// push adds item as the most recently accessed one.
+func (l *lru) push(item *CacheItem) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.elems[item] = l.order.PushFront(item)
+}
+
+// touch marks item as the most recently accessed one, if it is known.
+func (l *lru) touch(item *CacheItem) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if e, ok := l.elems[item]; ok {
+		l.order.MoveToFront(e)
+	}
+}
+
+func (l *lru) remove(item *CacheItem) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if e, ok := l.elems[item]; ok {
+		l.order.Remove(e)
+		delete(l.elems, item)
+	}
+}
+
+// oldest returns the n least recently accessed items, oldest first.
+func (l *lru) oldest(n int) []*CacheItem {
+	l.Lock()
+	defer l.Unlock()
+	items := make([]*CacheItem, 0, n)
+	for e := l.order.Back(); e != nil && len(items) < n; e = e.Prev() {
+		items = append(items, e.Value.(*CacheItem))
+	}
+	return items
+}
+
+func (l *lru) reset() {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.order.Init()
+	l.elems = make(map[*CacheItem]*list.Element)
+}
+
+// Capacity returns the maximum number of items of the table, or 0 if it is
+// unlimited.
+func (table *CacheTable) Capacity() int {
+	// immutable
+	return table.capacity
+}
+
+// trim evicts the least recently accessed items while the table holds more
+// items than its capacity. Do not run it while holding the table-mutex.
+func (table *CacheTable) trim() {
+	if table.capacity == 0 {
+		return
+	}
+	table.Lock()
+	n := len(table.items) - table.capacity
+	if n <= 0 || table.closed {
+		table.Unlock()
+		return
+	}
+
+	victims := table.lru.oldest(n)
+	var traces []*trace
+	for _, r := range victims {
+		tr := table.startTrace(context.Background(), table.tracer, OpEvict, r.key)
+		tr.reason(ReasonCapacity)
+		traces = append(traces, tr)
+		table.log(slog.LevelDebug, "Evicting item", table.keyAttr(r.key), slog.Int("capacity", table.capacity))
+		table.unshareInternal()
+		delete(table.items, r.key)
+		table.untagInternal(r)
+		table.unindexInternal(r.key)
+		table.lru.remove(r)
+	}
+	table.stats.evict(ReasonCapacity, len(victims))
+	aboutToDeleteItem := table.aboutToDeleteItem
+	table.Unlock()
+
+	for i, r := range victims {
+		table.notifyDelete(aboutToDeleteItem, r, ReasonCapacity)
+		traces[i].end(nil)
+	}
+}
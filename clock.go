@@ -0,0 +1,63 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of a table: item timestamps, expiry and the
+// janitor use it. Tests can swap it for a fake, e.g. cachertest.FakeClock,
+// with WithClock. Durations measured for the stats and tracers always use
+// the system clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Tick calls f every d, which is positive, until stop gets called.
+	Tick(d time.Duration, f func()) (stop func())
+}
+
+// SystemClock is the Clock of the time package. It is the default.
+type SystemClock struct{}
+
+// Now returns time.Now().
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Tick calls f every d from a new goroutine, using a time.Ticker.
+func (SystemClock) Tick(d time.Duration, f func()) (stop func()) {
+	ticker := time.NewTicker(d)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				f()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Clock returns the clock of the table.
+func (table *CacheTable) Clock() Clock {
+	// immutable
+	return table.clock
+}
+
+// newItem returns a new item with the timestamps of the table's clock.
+func (table *CacheTable) newItem(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	return newCacheItem(table.clock, key, lifeSpan, data)
+}
@@ -0,0 +1,146 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// ComputeOp tells Compute what to do with the key.
+type ComputeOp int
+
+const (
+	// ComputeKeep leaves the key as it is.
+	ComputeKeep ComputeOp = iota
+	// ComputeSet stores the returned data and lifespan under the key.
+	ComputeSet
+	// ComputeDelete deletes the key.
+	ComputeDelete
+)
+
+// ComputeFunc computes the new data of a key from its current item. old is
+// nil if exists is false.
+type ComputeFunc func(old *CacheItem, exists bool) (data interface{}, lifeSpan time.Duration, op ComputeOp)
+
+// keyLockStripes is the number of locks the keys of a table share for
+// Compute.
+const keyLockStripes = 64
+
+// GetOrSet returns the item of key, marking it to be kept alive, if it
+// exists. Otherwise it stores data under key and returns the new item.
+// loaded reports whether the item existed.
+func (table *CacheTable) GetOrSet(key interface{}, lifeSpan time.Duration, data interface{}) (item *CacheItem, loaded bool) {
+	return table.getOrSet(key, lifeSpan, data, true)
+}
+
+func (table *CacheTable) getOrSet(key interface{}, lifeSpan time.Duration, data interface{}, keepAlive bool) (item *CacheItem, loaded bool) {
+	start := time.Now()
+	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return nil, false
+	}
+	if r, ok := table.items[key]; ok {
+		table.Unlock()
+		if keepAlive {
+			r.access()
+			table.lru.touch(r)
+		}
+		return r, true
+	}
+
+	tr := table.startTraceAt(context.Background(), table.tracer, OpSet, key, start)
+	item = table.newItem(key, lifeSpan, data)
+	table.addInternal(item)
+	table.Unlock()
+	table.stats.set()
+	tr.end(nil)
+
+	table.publishKey(key)
+	table.trim()
+	return item, false
+}
+
+// Compute atomically updates key with the result of fn, and returns the
+// resulting item and whether the key exists afterwards. Callbacks fire as
+// for Set and Delete.
+//
+// fn runs without holding the table lock. Compute calls on the same key are
+// serialized, but if the key gets changed by another method while fn runs,
+// fn is called again with the new item.
+func (table *CacheTable) Compute(key interface{}, fn ComputeFunc) (*CacheItem, bool) {
+	return table.compute(key, fn, true, true)
+}
+
+// ComputeIfAbsent is like Compute, but only calls fn if key doesn't exist.
+func (table *CacheTable) ComputeIfAbsent(key interface{}, fn ComputeFunc) (*CacheItem, bool) {
+	return table.compute(key, fn, true, false)
+}
+
+// ComputeIfPresent is like Compute, but only calls fn if key exists.
+func (table *CacheTable) ComputeIfPresent(key interface{}, fn ComputeFunc) (*CacheItem, bool) {
+	return table.compute(key, fn, false, true)
+}
+
+func (table *CacheTable) compute(key interface{}, fn ComputeFunc, ifAbsent, ifPresent bool) (*CacheItem, bool) {
+	mu := &table.keyLocks[keyStripe(key)]
+	mu.Lock()
+	defer mu.Unlock()
+
+	for {
+		table.RLock()
+		old, exists := table.items[key]
+		closed := table.closed
+		table.RUnlock()
+		if closed {
+			return nil, false
+		}
+		if (exists && !ifPresent) || (!exists && !ifAbsent) {
+			return old, exists
+		}
+		// Versions are unique within the table, so an unchanged version
+		// means an unchanged item. 0 means the key must still be absent.
+		var version uint64
+		if exists {
+			version = old.Version()
+		}
+
+		data, lifeSpan, op := fn(old, exists)
+		switch op {
+		case ComputeSet:
+			item, err := table.CompareAndSwap(key, version, data, lifeSpan)
+			if err == nil {
+				return item, true
+			}
+		case ComputeDelete:
+			if !exists {
+				return nil, false
+			}
+			if _, err := table.CompareAndDelete(key, version); err == nil {
+				return nil, false
+			}
+		default:
+			return old, exists
+		}
+		// The key changed meanwhile, try again.
+	}
+}
+
+// keyStripe returns the index of the key lock for key.
+func keyStripe(key interface{}) int {
+	h := fnv.New32a()
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte(k))
+	default:
+		fmt.Fprintf(h, "%T:%v", key, key)
+	}
+	return int(h.Sum32() % keyLockStripes)
+}
@@ -0,0 +1,103 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetOrSet(t *testing.T) {
+	table := newCacheTable("testGetOrSet", time.Minute)
+	item, loaded := table.GetOrSet(k, time.Hour, "first")
+	if loaded || item.Data() != "first" || item.LifeSpan() != time.Hour {
+		t.Errorf("Expected a new item, got %v (loaded %v)", item.Data(), loaded)
+	}
+	item, loaded = table.GetOrSet(k, 0, "second")
+	if !loaded || item.Data() != "first" || item.AccessCount() != 1 {
+		t.Errorf("Expected the existing item, got %v (loaded %v)", item.Data(), loaded)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	table := newCacheTable("testCompute", time.Minute)
+	var reasons []EvictionReason
+	table.SetAboutToDeleteItemWithReasonCallback(func(item *CacheItem, reason EvictionReason) {
+		reasons = append(reasons, reason)
+	})
+	appendFn := func(old *CacheItem, exists bool) (interface{}, time.Duration, ComputeOp) {
+		if !exists {
+			return "a", 0, ComputeSet
+		}
+		return old.Data().(string) + "a", 0, ComputeSet
+	}
+
+	table.Compute(k, appendFn)
+	if item, ok := table.Compute(k, appendFn); !ok || item.Data() != "aa" {
+		t.Errorf("Unexpected computed item %v", item)
+	}
+	if item, ok := table.ComputeIfAbsent(k, appendFn); !ok || item.Data() != "aa" {
+		t.Error("ComputeIfAbsent changed an existing key")
+	}
+	if _, ok := table.ComputeIfPresent("missing", appendFn); ok || table.Exists("missing") {
+		t.Error("ComputeIfPresent created a key")
+	}
+	if item, ok := table.ComputeIfAbsent("missing", appendFn); !ok || item.Data() != "a" {
+		t.Error("ComputeIfAbsent didn't create the key")
+	}
+
+	_, ok := table.ComputeIfPresent(k, func(old *CacheItem, exists bool) (interface{}, time.Duration, ComputeOp) {
+		return nil, 0, ComputeDelete
+	})
+	if ok || table.Exists(k) {
+		t.Error("ComputeDelete didn't delete the key")
+	}
+	if len(reasons) != 2 || reasons[0] != ReasonReplaced || reasons[1] != ReasonDeleted {
+		t.Errorf("Unexpected callback reasons %v", reasons)
+	}
+}
+
+func TestComputeRetry(t *testing.T) {
+	table := newCacheTable("testComputeRetry", time.Minute)
+	table.Set(k, 0, 1)
+	calls := 0
+	item, _ := table.Compute(k, func(old *CacheItem, exists bool) (interface{}, time.Duration, ComputeOp) {
+		calls++
+		if calls == 1 {
+			// another writer changes the key while we compute
+			table.Set(k, 0, 10)
+		}
+		return old.Data().(int) + 1, 0, ComputeSet
+	})
+	if calls != 2 || item.Data() != 11 {
+		t.Errorf("Expected a retry on the new item, got %v after %d calls", item.Data(), calls)
+	}
+}
+
+func TestComputeConcurrent(t *testing.T) {
+	table := newCacheTable("testComputeConcurrent", time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				table.Compute(k, func(old *CacheItem, exists bool) (interface{}, time.Duration, ComputeOp) {
+					if !exists {
+						return 1, 0, ComputeSet
+					}
+					return old.Data().(int) + 1, 0, ComputeSet
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if item, _ := table.Get(k); item.Data() != 500 {
+		t.Errorf("Expected 500, got %v", item.Data())
+	}
+}
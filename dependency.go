@@ -0,0 +1,248 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxCascadeDepth bounds how many levels of dependents get invalidated when
+// an item changes. Dependents further away stay cached and lose their
+// dependencies.
+var MaxCascadeDepth = 16
+
+// Dependency names a cached item another one depends on.
+type Dependency struct {
+	// Table is the name of the table in the registry of the dependent item.
+	// Empty means the table of the dependent item.
+	Table string
+	// Key is the key of the item.
+	Key interface{}
+}
+
+// depNode is an item in the dependency graph.
+type depNode struct {
+	table *CacheTable
+	key   interface{}
+}
+
+// dependencyGraph links items across all tables and registries, so it is
+// global. Each table counts its nodes in the graph, so tables without
+// dependencies don't need to lock it.
+type dependencyGraph struct {
+	sync.Mutex
+	// dependents maps an item to the items depending on it.
+	dependents map[depNode]map[depNode]struct{}
+	// dependencies maps an item to the items it depends on.
+	dependencies map[depNode]map[depNode]struct{}
+}
+
+var dependencies = dependencyGraph{
+	dependents:   make(map[depNode]map[depNode]struct{}),
+	dependencies: make(map[depNode]map[depNode]struct{}),
+}
+
+// AddDependency declares that the item of key depends on deps: when any of
+// them gets deleted, expires or is replaced, key gets deleted as well with
+// ReasonDependency, which in turn cascades to its own dependents. Returns
+// ErrKeyNotFound if key isn't in the table, ErrDependencyCycle if one of
+// deps already depends on key, or ErrTableNotFound if the table of one of
+// them doesn't exist, in which case none are added.
+//
+// Dependencies belong to the key rather than the item, and get dropped when
+// the item leaves the table or is replaced, or the table is closed. Declare
+// them again for the new item.
+func (table *CacheTable) AddDependency(key interface{}, deps ...Dependency) error {
+	node := depNode{table, key}
+	nodes := make([]depNode, len(deps))
+	for i, dep := range deps {
+		nodes[i] = depNode{table, dep.Key}
+		if dep.Table == "" || dep.Table == table.name {
+			continue
+		}
+		var ok bool
+		if table.registry != nil {
+			nodes[i].table, ok = table.registry.Lookup(dep.Table)
+		}
+		if !ok {
+			return ErrTableNotFound
+		}
+	}
+
+	// Holding the read lock keeps key in the table until it is linked, so
+	// its removal drops the edges again.
+	table.RLock()
+	defer table.RUnlock()
+	if table.closed {
+		return ErrTableClosed
+	}
+	if _, ok := table.items[key]; !ok {
+		return ErrKeyNotFound
+	}
+
+	g := &dependencies
+	g.Lock()
+	defer g.Unlock()
+	for _, dep := range nodes {
+		if g.reaches(dep, node) {
+			return ErrDependencyCycle
+		}
+	}
+	for _, dep := range nodes {
+		g.link(g.dependents, dep, node)
+		g.link(g.dependencies, node, dep)
+	}
+	return nil
+}
+
+// Dependents returns the items directly depending on key.
+func (table *CacheTable) Dependents(key interface{}) []Dependency {
+	g := &dependencies
+	g.Lock()
+	defer g.Unlock()
+	var deps []Dependency
+	for n := range g.dependents[depNode{table, key}] {
+		deps = append(deps, Dependency{n.table.name, n.key})
+	}
+	return deps
+}
+
+// reaches reports whether to can be reached from from by following
+// dependencies. The caller must hold the lock.
+func (g *dependencyGraph) reaches(from, to depNode) bool {
+	seen := make(map[depNode]struct{})
+	stack := []depNode{from}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n == to {
+			return true
+		}
+		if _, ok := seen[n]; ok {
+			continue
+		}
+		seen[n] = struct{}{}
+		for dep := range g.dependencies[n] {
+			stack = append(stack, dep)
+		}
+	}
+	return false
+}
+
+// has reports whether n is in the graph. The caller must hold the lock.
+func (g *dependencyGraph) has(n depNode) bool {
+	_, dependent := g.dependencies[n]
+	_, dependency := g.dependents[n]
+	return dependent || dependency
+}
+
+func (g *dependencyGraph) link(edges map[depNode]map[depNode]struct{}, from, to depNode) {
+	if !g.has(from) {
+		atomic.AddInt64(&from.table.depNodes, 1)
+	}
+	set := edges[from]
+	if set == nil {
+		set = make(map[depNode]struct{})
+		edges[from] = set
+	}
+	set[to] = struct{}{}
+}
+
+func (g *dependencyGraph) unlink(edges map[depNode]map[depNode]struct{}, from, to depNode) {
+	set := edges[from]
+	delete(set, to)
+	if len(set) == 0 {
+		delete(edges, from)
+		if !g.has(from) {
+			atomic.AddInt64(&from.table.depNodes, -1)
+		}
+	}
+}
+
+// remove drops n from the graph and returns its former dependents. The
+// caller must hold the lock.
+func (g *dependencyGraph) remove(n depNode) []depNode {
+	if !g.has(n) {
+		return nil
+	}
+	atomic.AddInt64(&n.table.depNodes, -1)
+	for dep := range g.dependencies[n] {
+		g.unlink(g.dependents, dep, n)
+	}
+	delete(g.dependencies, n)
+
+	var out []depNode
+	for d := range g.dependents[n] {
+		out = append(out, d)
+		g.unlink(g.dependencies, d, n)
+	}
+	delete(g.dependents, n)
+	return out
+}
+
+// cascade deletes the dependents of key, which left the table or got
+// replaced, down to MaxCascadeDepth levels. Do not run it while holding the
+// table-mutex.
+func (table *CacheTable) cascade(key interface{}) {
+	if atomic.LoadInt64(&table.depNodes) == 0 {
+		return
+	}
+	g := &dependencies
+	g.Lock()
+
+	// Take the affected part out of the graph first, so the deletions
+	// below don't cascade again.
+	var invalid []depNode
+	level := g.remove(depNode{table, key})
+	for depth := 1; len(level) > 0; depth++ {
+		if depth > MaxCascadeDepth {
+			table.log(slog.LevelWarn, "Dependency cascade too deep", table.keyAttr(key), slog.Int("depth", depth))
+			break
+		}
+		var next []depNode
+		for _, n := range level {
+			invalid = append(invalid, n)
+			next = append(next, g.remove(n)...)
+		}
+		level = next
+	}
+	// Dependents beyond the bound stay cached but lose their dependencies.
+	for _, n := range level {
+		g.remove(n)
+	}
+	g.Unlock()
+
+	for _, n := range invalid {
+		n.table.removeMany(ReasonDependency, func() []interface{} {
+			return []interface{}{n.key}
+		})
+	}
+}
+
+// purgeDependencies drops all nodes of the table from the graph, once it is
+// closed.
+func (table *CacheTable) purgeDependencies() {
+	if atomic.LoadInt64(&table.depNodes) == 0 {
+		return
+	}
+	g := &dependencies
+	g.Lock()
+	defer g.Unlock()
+	var nodes []depNode
+	for _, edges := range []map[depNode]map[depNode]struct{}{g.dependents, g.dependencies} {
+		for n := range edges {
+			if n.table == table {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	for _, n := range nodes {
+		g.remove(n)
+	}
+}
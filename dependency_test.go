@@ -0,0 +1,143 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDependency(t *testing.T) {
+	table := newCacheTable("testDependency", time.Minute)
+	var reasons []EvictionReason
+	table.SetAboutToDeleteItemWithReasonCallback(func(item *CacheItem, reason EvictionReason) {
+		if item.Key() == "aggregate" {
+			reasons = append(reasons, reason)
+		}
+	})
+
+	table.Set("b", 0, v)
+	table.Set("c", 0, v)
+	table.Set("aggregate", 0, v)
+	if err := table.AddDependency("aggregate", Dependency{Key: "b"}, Dependency{Key: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if deps := table.Dependents("b"); len(deps) != 1 || deps[0].Key != "aggregate" {
+		t.Errorf("Unexpected dependents %v", deps)
+	}
+
+	// replacing a dependency invalidates the dependent
+	table.Set("b", 0, "new")
+	if table.Exists("aggregate") {
+		t.Error("Dependent wasn't invalidated")
+	}
+	if len(reasons) != 1 || reasons[0] != ReasonDependency {
+		t.Errorf("Unexpected reasons %v", reasons)
+	}
+	// the dependent's other dependencies are gone as well
+	if deps := table.Dependents("c"); len(deps) != 0 {
+		t.Errorf("Stale dependents %v", deps)
+	}
+
+	// a new item of a key starts without dependencies
+	table.Set("aggregate", 0, v)
+	table.AddDependency("aggregate", Dependency{Key: "c"})
+	table.Set("aggregate", 0, v)
+	table.Delete("c")
+	if !table.Exists("aggregate") {
+		t.Error("Dependency of the replaced item invalidated the new one")
+	}
+}
+
+func TestDependencyCascade(t *testing.T) {
+	other := New("testDependencyOther", time.Minute)
+	defer other.Close()
+	table := New("testDependencyCascade", time.Minute)
+	defer table.Close()
+	table.Set("base", 0, v)
+	table.Set("middle", 0, v)
+	other.Set("top", 0, v)
+	table.AddDependency("middle", Dependency{Key: "base"})
+	other.AddDependency("top", Dependency{Table: "testDependencyCascade", Key: "middle"})
+
+	table.Set("base", time.Nanosecond, v)
+	time.Sleep(time.Millisecond)
+	table.ExpirationCheck()
+	if table.Exists("middle") || other.Exists("top") {
+		t.Error("Expiry didn't cascade across tables")
+	}
+}
+
+func TestDependencyCycle(t *testing.T) {
+	table := newCacheTable("testDependencyCycle", time.Minute)
+	for _, key := range []string{"a", "b", "c"} {
+		table.Set(key, 0, v)
+	}
+	if err := table.AddDependency("a", Dependency{Key: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	table.AddDependency("b", Dependency{Key: "c"})
+	if err := table.AddDependency("c", Dependency{Key: "a"}); err != ErrDependencyCycle {
+		t.Errorf("Expected ErrDependencyCycle, got %v", err)
+	}
+	if err := table.AddDependency("a", Dependency{Key: "a"}); err != ErrDependencyCycle {
+		t.Errorf("Expected ErrDependencyCycle for a self-dependency, got %v", err)
+	}
+}
+
+func TestDependencyDepth(t *testing.T) {
+	defer func(depth int) { MaxCascadeDepth = depth }(MaxCascadeDepth)
+	MaxCascadeDepth = 2
+
+	table := newCacheTable("testDependencyDepth", time.Minute)
+	for i := 0; i < 5; i++ {
+		table.Set(i, 0, v)
+		if i > 0 {
+			table.AddDependency(i, Dependency{Key: i - 1})
+		}
+	}
+	table.Delete(0)
+	for i, want := range []bool{false, false, false, true, true} {
+		if table.Exists(i) != want {
+			t.Errorf("Expected item %d to exist: %v", i, want)
+		}
+	}
+	if deps := table.Dependents(3); len(deps) != 0 {
+		t.Errorf("Dependencies beyond the bound weren't dropped: %v", deps)
+	}
+}
+
+func TestDependencyCleanup(t *testing.T) {
+	table := New("testDependencyCleanup", time.Minute)
+	other := New("testDependencyCleanupOther", time.Minute)
+	defer other.Close()
+	if err := table.AddDependency("missing", Dependency{Key: "a"}); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound for a missing key, got %v", err)
+	}
+	if n := atomic.LoadInt64(&table.depNodes); n != 0 {
+		t.Errorf("Failed dependency left %d nodes", n)
+	}
+
+	table.Set("a", 0, v)
+	table.Set("b", 0, v)
+	other.Set("c", 0, v)
+	table.AddDependency("b", Dependency{Key: "a"})
+	other.AddDependency("c", Dependency{Table: "testDependencyCleanup", Key: "never-stored"})
+	table.Delete("b")
+	if n := atomic.LoadInt64(&table.depNodes); n != 1 {
+		t.Errorf("Expected only the dependency of c to remain, got %d nodes", n)
+	}
+
+	table.Close()
+	if n := atomic.LoadInt64(&table.depNodes); n != 0 {
+		t.Errorf("Closing left %d nodes of the table", n)
+	}
+	if deps := other.Dependents("c"); len(deps) != 0 || atomic.LoadInt64(&other.depNodes) != 0 {
+		t.Error("Closing left edges to the table")
+	}
+}
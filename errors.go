@@ -16,4 +16,30 @@ var (
 	// ErrKeyNotFoundOrLoadable gets returned when a specific key couldn't be
 	// found and loading via the data-loader callback also failed
 	ErrKeyNotFoundOrLoadable = errors.New("Key not found and could not be loaded into cache")
+	// ErrNotNumeric gets returned when incrementing an item whose data isn't
+	// of a matching numeric type
+	ErrNotNumeric = errors.New("Item data is not numeric")
+	// ErrVersionMismatch gets returned when a compare-and-swap finds that the
+	// item changed since the expected version was read
+	ErrVersionMismatch = errors.New("Item version does not match")
+	// ErrDependencyCycle gets returned when a dependency would make an item
+	// depend on itself
+	ErrDependencyCycle = errors.New("Dependency would create a cycle")
+	// ErrNoOrderedIndex gets returned by ordered scans of a table without an
+	// ordered index
+	ErrNoOrderedIndex = errors.New("Table has no ordered index")
+	// ErrTableClosed gets returned by operations on a closed table
+	ErrTableClosed = errors.New("Table is closed")
+	// ErrTableNotFound gets returned when a table couldn't be found in the
+	// cache
+	ErrTableNotFound = errors.New("Table not found in cache")
+	// ErrInvalidConfig gets returned when a table option has an invalid
+	// value
+	ErrInvalidConfig = errors.New("Invalid table configuration")
+	// ErrConfigConflict gets returned when reopening a table with a
+	// configuration different from its own
+	ErrConfigConflict = errors.New("Table exists with a different configuration")
+	// ErrQueueFull gets reported when an invalidation is dropped because a
+	// peer doesn't keep up
+	ErrQueueFull = errors.New("Invalidation queue is full")
 )
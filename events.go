@@ -0,0 +1,242 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change an Event reports.
+type EventType int
+
+const (
+	// EventAdd means a new key was stored.
+	EventAdd EventType = iota
+	// EventUpdate means an existing key got a new item, by Set or by a
+	// reload of the expiration check.
+	EventUpdate
+	// EventDelete means an item was deleted or taken explicitly.
+	EventDelete
+	// EventExpire means an item's lifeSpan elapsed, including items whose
+	// reload failed.
+	EventExpire
+	// EventEvict means an item left the table for any other reason, e.g. a
+	// flush.
+	EventEvict
+)
+
+var eventTypeNames = [...]string{
+	EventAdd:    "add",
+	EventUpdate: "update",
+	EventDelete: "delete",
+	EventExpire: "expire",
+	EventEvict:  "evict",
+}
+
+// String returns the name of the event type.
+func (e EventType) String() string {
+	if e < 0 || int(e) >= len(eventTypeNames) {
+		return fmt.Sprintf("EventType(%d)", int(e))
+	}
+	return eventTypeNames[e]
+}
+
+// Event describes a change of a table.
+type Event struct {
+	// Type is the kind of change.
+	Type EventType
+	// Table is the name of the table.
+	Table string
+	// Key is the key of the changed item.
+	Key interface{}
+	// Item is the new item for EventAdd and EventUpdate, the removed one
+	// otherwise.
+	Item *CacheItem
+	// Reason is why the item left the table. Only valid for EventDelete,
+	// EventExpire and EventEvict.
+	Reason EvictionReason
+	// Time is when the change happened.
+	Time time.Time
+}
+
+// OverflowPolicy decides what happens to events for a subscriber whose
+// buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop discards the event and counts it in
+	// StatsSnapshot.DroppedEvents.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock waits until the subscriber makes room. Changes of the
+	// table block meanwhile, partly while the table is locked.
+	OverflowBlock
+)
+
+// DefaultEventBuffer is the buffer size of subscriptions which don't set one.
+const DefaultEventBuffer = 64
+
+// EventFilter selects the events of a subscription and how they get
+// delivered. The zero value subscribes to all events.
+type EventFilter struct {
+	// Types are the event types to deliver. Empty means all.
+	Types []EventType
+	// Key, if set, selects the keys to deliver events for.
+	Key func(key interface{}) bool
+	// Buffer is the capacity of the channel. Zero means DefaultEventBuffer.
+	Buffer int
+	// Overflow is the policy for a full buffer.
+	Overflow OverflowPolicy
+}
+
+type subscription struct {
+	filter EventFilter
+	ch     chan Event
+	// done is closed on cancel, releasing blocked senders.
+	done chan struct{}
+	// mu guards closed and ch against sends after close.
+	mu     sync.RWMutex
+	closed bool
+	once   sync.Once
+}
+
+// Subscribe returns a channel receiving the events selected by filter. Call
+// cancel to stop the subscription; it closes the channel, as does closing
+// the table. On a closed table the channel is closed already. Unlike
+// callbacks, subscribers can't slow down the table unless they choose
+// OverflowBlock.
+func (table *CacheTable) Subscribe(filter EventFilter) (events <-chan Event, cancel func()) {
+	if filter.Buffer <= 0 {
+		filter.Buffer = DefaultEventBuffer
+	}
+	sub := &subscription{
+		filter: filter,
+		ch:     make(chan Event, filter.Buffer),
+		done:   make(chan struct{}),
+	}
+
+	// Holding the table lock, Close either sees the subscription or has
+	// already marked the table closed.
+	table.RLock()
+	closed := table.closed
+	if !closed {
+		table.subscriptionsMu.Lock()
+		subs := append(append([]*subscription(nil), table.subscriptions()...), sub)
+		table.subscriptionList.Store(&subs)
+		table.subscriptionsMu.Unlock()
+	}
+	table.RUnlock()
+	if closed {
+		table.unsubscribe(sub)
+	}
+
+	return sub.ch, func() {
+		table.unsubscribe(sub)
+	}
+}
+
+// unsubscribe ends sub, closing its channel. It may be called repeatedly.
+func (table *CacheTable) unsubscribe(sub *subscription) {
+	sub.once.Do(func() {
+		close(sub.done)
+		table.subscriptionsMu.Lock()
+		var subs []*subscription
+		for _, s := range table.subscriptions() {
+			if s != sub {
+				subs = append(subs, s)
+			}
+		}
+		table.subscriptionList.Store(&subs)
+		table.subscriptionsMu.Unlock()
+
+		sub.mu.Lock()
+		sub.closed = true
+		close(sub.ch)
+		sub.mu.Unlock()
+	})
+}
+
+func (table *CacheTable) subscriptions() []*subscription {
+	if subs := table.subscriptionList.Load(); subs != nil {
+		return *subs
+	}
+	return nil
+}
+
+// emit delivers an event to all matching subscribers.
+func (table *CacheTable) emit(typ EventType, item *CacheItem, reason EvictionReason) {
+	subs := table.subscriptions()
+	if len(subs) == 0 {
+		return
+	}
+	ev := Event{
+		Type:   typ,
+		Table:  table.name,
+		Key:    item.key,
+		Item:   item,
+		Reason: reason,
+		Time:   table.clock.Now(),
+	}
+	for _, sub := range subs {
+		if sub.matches(ev) && !sub.send(ev) {
+			table.stats.dropEvent()
+		}
+	}
+}
+
+// emitRemoval delivers the event for an item which left the table.
+// Replaced items are already covered by EventUpdate.
+func (table *CacheTable) emitRemoval(item *CacheItem, reason EvictionReason) {
+	switch reason {
+	case ReasonReplaced:
+	case ReasonDeleted, ReasonTaken:
+		table.emit(EventDelete, item, reason)
+	case ReasonExpired, ReasonLoadFailed:
+		table.emit(EventExpire, item, reason)
+	default:
+		table.emit(EventEvict, item, reason)
+	}
+}
+
+func (sub *subscription) matches(ev Event) bool {
+	if len(sub.filter.Types) > 0 {
+		found := false
+		for _, t := range sub.filter.Types {
+			if t == ev.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return sub.filter.Key == nil || sub.filter.Key(ev.Key)
+}
+
+// send reports false if the event was dropped.
+func (sub *subscription) send(ev Event) bool {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+	if sub.closed {
+		return true
+	}
+	if sub.filter.Overflow == OverflowBlock {
+		select {
+		case sub.ch <- ev:
+		case <-sub.done:
+		}
+		return true
+	}
+	select {
+	case sub.ch <- ev:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	table := newCacheTable("testSubscribe", time.Minute)
+	events, cancel := table.Subscribe(EventFilter{})
+	defer cancel()
+
+	table.Set(k, 0, v)
+	table.Set(k, 0, v)
+	table.Delete(k)
+	table.Set("expiring", time.Nanosecond, v)
+	time.Sleep(time.Millisecond)
+	table.ExpirationCheck()
+	table.Set("flushed", 0, v)
+	table.Flush()
+
+	for _, want := range []struct {
+		typ    EventType
+		key    string
+		reason EvictionReason
+	}{
+		{EventAdd, k, 0},
+		{EventUpdate, k, 0},
+		{EventDelete, k, ReasonDeleted},
+		{EventAdd, "expiring", 0},
+		{EventExpire, "expiring", ReasonExpired},
+		{EventAdd, "flushed", 0},
+		{EventEvict, "flushed", ReasonFlushed},
+	} {
+		select {
+		case ev := <-events:
+			if ev.Type != want.typ || ev.Key != want.key || ev.Table != "testSubscribe" {
+				t.Errorf("Expected %v of %s, got %v of %v", want.typ, want.key, ev.Type, ev.Key)
+			}
+			if (ev.Type == EventDelete || ev.Type == EventExpire || ev.Type == EventEvict) && ev.Reason != want.reason {
+				t.Errorf("Expected reason %v, got %v", want.reason, ev.Reason)
+			}
+		default:
+			t.Fatalf("Missing %v event of %s", want.typ, want.key)
+		}
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	table := newCacheTable("testSubscribeFilter", time.Minute)
+	events, cancel := table.Subscribe(EventFilter{
+		Types: []EventType{EventDelete},
+		Key: func(key interface{}) bool {
+			return strings.HasPrefix(key.(string), "user:")
+		},
+	})
+
+	table.Set("user:1", 0, v)
+	table.Set("session:1", 0, v)
+	table.Delete("user:1")
+	table.Delete("session:1")
+	cancel()
+
+	var got []Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != 1 || got[0].Type != EventDelete || got[0].Key != "user:1" {
+		t.Errorf("Unexpected events %v", got)
+	}
+
+	// changes after cancel and cancelling twice are harmless
+	table.Set("user:2", 0, v)
+	cancel()
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	table := newCacheTable("testSubscribeOverflow", time.Minute)
+	dropping, cancelDrop := table.Subscribe(EventFilter{Buffer: 1})
+	defer cancelDrop()
+	blocking, cancelBlock := table.Subscribe(EventFilter{Buffer: 1, Overflow: OverflowBlock})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			table.Set(i, 0, v)
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		if ev := <-blocking; ev.Key != i {
+			t.Errorf("Expected key %d, got %v", i, ev.Key)
+		}
+	}
+	<-done
+	cancelBlock()
+
+	if len(dropping) != 1 {
+		t.Errorf("Expected a full buffer, got %d events", len(dropping))
+	}
+	if dropped := table.Stats().Snapshot().DroppedEvents; dropped != 2 {
+		t.Errorf("Expected 2 dropped events, got %d", dropped)
+	}
+}
+
+func TestSubscribeCancelUnblocks(t *testing.T) {
+	table := newCacheTable("testSubscribeCancel", time.Minute)
+	_, cancel := table.Subscribe(EventFilter{Buffer: 1, Overflow: OverflowBlock})
+
+	done := make(chan struct{})
+	go func() {
+		table.Set(1, 0, v)
+		table.Set(2, 0, v)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Blocked writer wasn't released by cancel")
+	}
+}
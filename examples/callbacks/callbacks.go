@@ -8,7 +8,10 @@ import (
 )
 
 func main() {
-	cache := cacher.New("myCache",1)
+	cache, err := cacher.NewWithOptions("myCache", cacher.WithCleanupInterval(time.Second))
+	if err != nil {
+		panic(err)
+	}
 
 	// This callback will be triggered every time a new item
 	// gets added to the cache.
@@ -0,0 +1,75 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"expvar"
+	"fmt"
+)
+
+// PublishExpvar publishes the counts and stats of all tables as the expvar
+// map name, keyed by table name, so they show up in /debug/vars. Tables
+// created later get added as well. Calling it again has no effect.
+func PublishExpvar(name string) error {
+	return defaultRegistry.PublishExpvar(name)
+}
+
+// PublishExpvar is like the package-level PublishExpvar for the tables of
+// this registry.
+func (r *Registry) PublishExpvar(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.expvarTables != nil {
+		return nil
+	}
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("cacher: expvar %q is already published", name)
+	}
+
+	r.expvarTables = expvar.NewMap(name)
+	for _, t := range r.tables {
+		r.publishTable(t)
+	}
+	return nil
+}
+
+// unpublishTable removes t from the published map, if any. The caller must
+// hold r.mu.
+func (r *Registry) unpublishTable(t *CacheTable) {
+	if r.expvarTables != nil {
+		r.expvarTables.Delete(t.name)
+	}
+}
+
+// publishTable adds t to the published map, if any. The caller must hold
+// r.mu.
+func (r *Registry) publishTable(t *CacheTable) {
+	if r.expvarTables == nil {
+		return
+	}
+	r.expvarTables.Set(t.name, expvar.Func(func() interface{} {
+		s := t.Stats().Snapshot()
+		return map[string]interface{}{
+			"count":          t.Count(),
+			"hits":           s.Hits,
+			"misses":         s.Misses,
+			"hitRatio":       s.HitRatio(),
+			"loads":          s.Loads,
+			"loadSuccesses":  s.LoadSuccesses,
+			"loadFailures":   s.LoadFailures,
+			"loadSeconds":    s.LoadTime.Seconds(),
+			"sets":           s.Sets,
+			"deletes":        s.Deletes,
+			"expirations":    s.Expirations,
+			"evictions":      s.Evictions,
+			"janitorRuns":    s.JanitorRuns,
+			"janitorSeconds": s.JanitorTime.Seconds(),
+			"droppedEvents":  s.DroppedEvents,
+		}
+	}))
+}
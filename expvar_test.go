@@ -0,0 +1,50 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	t.Cleanup(func() {
+		Drop("testExpvarBefore")
+		Drop("testExpvarAfter")
+	})
+	before := New("testExpvarBefore", time.Minute)
+	before.Set(k, 0, v)
+	if err := PublishExpvar("cacherTest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishExpvar("cacherTest"); err != nil {
+		t.Error("Publishing twice failed:", err)
+	}
+	after := New("testExpvarAfter", time.Minute)
+	after.Get(k)
+
+	tables := expvar.Get("cacherTest").(*expvar.Map)
+	for name, check := range map[string]func(map[string]interface{}) bool{
+		"testExpvarBefore": func(m map[string]interface{}) bool { return m["count"] == 1.0 },
+		"testExpvarAfter":  func(m map[string]interface{}) bool { return m["misses"] == 1.0 },
+	} {
+		v := tables.Get(name)
+		if v == nil {
+			t.Errorf("Table %s wasn't published", name)
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(v.String()), &m); err != nil {
+			t.Fatal(err)
+		}
+		if !check(m) {
+			t.Errorf("Unexpected vars for %s: %v", name, m)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+)
+
+// Invalidation is a message exchanged between replicas of a table, telling
+// them that a key (or the whole table) is stale.
+//
+// Network transports encode invalidations as JSON, so keys should be strings
+// when the table is shared across processes.
+type Invalidation struct {
+	// Origin identifies the table instance which published the message.
+	Origin string `json:"origin"`
+	// ID is unique among the messages of one origin.
+	ID uint64 `json:"id"`
+	// Table is the name of the table the message refers to.
+	Table string `json:"table"`
+	// Key is the invalidated key. It is ignored for flushes.
+	Key interface{} `json:"key,omitempty"`
+	// Flush is set when the whole table got flushed.
+	Flush bool `json:"flush,omitempty"`
+}
+
+// Invalidator is a transport for invalidations. A table publishes to it
+// whenever it sets or deletes a key and deletes keys announced by others.
+type Invalidator interface {
+	// Publish sends msg to all subscribers.
+	Publish(msg Invalidation) error
+	// Subscribe registers a handler for incoming messages. The returned
+	// function unregisters it again.
+	Subscribe(handler func(Invalidation)) (cancel func(), err error)
+}
+
+// invalidationWindow is how many message ids a table remembers to drop
+// duplicates delivered by the transport.
+const invalidationWindow = 1024
+
+// invalidation holds the state of a table connected to an Invalidator.
+type invalidation struct {
+	invalidator Invalidator
+	cancel      func()
+	origin      string
+
+	mu   sync.Mutex
+	seq  uint64
+	ring []invalidationID
+	pos  int
+	seen map[invalidationID]struct{}
+}
+
+type invalidationID struct {
+	origin string
+	id     uint64
+}
+
+func newInvalidation(inv Invalidator) *invalidation {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &invalidation{
+		invalidator: inv,
+		origin:      hex.EncodeToString(b),
+		ring:        make([]invalidationID, invalidationWindow),
+		seen:        make(map[invalidationID]struct{}, invalidationWindow),
+	}
+}
+
+// next returns a new message originating from this table.
+func (inv *invalidation) next(table string) Invalidation {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.seq++
+	return Invalidation{Origin: inv.origin, ID: inv.seq, Table: table}
+}
+
+// accept reports whether msg was published by someone else and hasn't been
+// seen before.
+func (inv *invalidation) accept(msg Invalidation) bool {
+	if msg.Origin == inv.origin {
+		return false
+	}
+
+	id := invalidationID{msg.Origin, msg.ID}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if _, ok := inv.seen[id]; ok {
+		return false
+	}
+	delete(inv.seen, inv.ring[inv.pos])
+	inv.ring[inv.pos] = id
+	inv.pos = (inv.pos + 1) % len(inv.ring)
+	inv.seen[id] = struct{}{}
+	return true
+}
+
+// SetInvalidator connects the table to an invalidation bus. Keys set or
+// deleted on this table get published to inv, and keys published by other
+// replicas of the table (tables with the same name) get deleted locally.
+// Passing nil disconnects the table.
+func (table *CacheTable) SetInvalidator(inv Invalidator) error {
+	var state *invalidation
+	if inv != nil {
+		state = newInvalidation(inv)
+		cancel, err := inv.Subscribe(func(msg Invalidation) {
+			table.applyInvalidation(state, msg)
+		})
+		if err != nil {
+			return err
+		}
+		state.cancel = cancel
+	}
+
+	table.Lock()
+	old := table.invalidation
+	table.invalidation = state
+	table.Unlock()
+
+	if old != nil {
+		old.cancel()
+	}
+	return nil
+}
+
+// EnableFlushBroadcast configures whether Flush gets published to the
+// other replicas as well. It has no effect without an Invalidator.
+func (table *CacheTable) EnableFlushBroadcast(b bool) {
+	table.Lock()
+	defer table.Unlock()
+	table.broadcastFlush = b
+}
+
+// publishKey announces key as stale to the other replicas.
+func (table *CacheTable) publishKey(key interface{}) {
+	table.RLock()
+	inv := table.invalidation
+	table.RUnlock()
+	if inv == nil {
+		return
+	}
+
+	msg := inv.next(table.name)
+	msg.Key = key
+	if err := inv.invalidator.Publish(msg); err != nil {
+		table.log(slog.LevelWarn, "Publishing invalidation failed", table.keyAttr(key), slog.Any("error", err))
+	}
+}
+
+// publishFlush announces a flush to the other replicas, if enabled.
+func (table *CacheTable) publishFlush() {
+	table.RLock()
+	inv := table.invalidation
+	broadcast := table.broadcastFlush
+	table.RUnlock()
+	if inv == nil || !broadcast {
+		return
+	}
+
+	msg := inv.next(table.name)
+	msg.Flush = true
+	if err := inv.invalidator.Publish(msg); err != nil {
+		table.log(slog.LevelWarn, "Publishing flush failed", slog.Any("error", err))
+	}
+}
+
+// applyInvalidation handles a message received from the bus without
+// publishing it again.
+func (table *CacheTable) applyInvalidation(inv *invalidation, msg Invalidation) {
+	if msg.Table != table.name || !inv.accept(msg) {
+		return
+	}
+
+	if msg.Flush {
+		table.log(slog.LevelDebug, "Flushing table on invalidation", slog.String("origin", msg.Origin))
+		table.flush()
+		return
+	}
+
+	table.Lock()
+	defer table.Unlock()
+	if _, ok := table.items[msg.Key]; ok {
+		table.log(slog.LevelDebug, "Invalidating item", table.keyAttr(msg.Key), slog.String("origin", msg.Origin))
+		table.deleteInternal(msg.Key, ReasonDeleted)
+	}
+}
+
+// subscribers is the handler registry shared by the built-in transports.
+type subscribers struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]func(Invalidation)
+}
+
+func (s *subscribers) add(handler func(Invalidation)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[int]func(Invalidation))
+	}
+	id := s.next
+	s.next++
+	s.handlers[id] = handler
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.handlers, id)
+	}
+}
+
+func (s *subscribers) dispatch(msg Invalidation) {
+	s.mu.RLock()
+	handlers := make([]func(Invalidation), 0, len(s.handlers))
+	for _, h := range s.handlers {
+		handlers = append(handlers, h)
+	}
+	s.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(msg)
+	}
+}
+
+// MemoryBus is an in-process Invalidator. All tables subscribed to the same
+// bus receive each other's invalidations synchronously, which makes it a
+// good fit for tests.
+type MemoryBus struct {
+	subs subscribers
+}
+
+// NewMemoryBus returns an empty in-memory bus.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Publish delivers msg to every subscriber before returning.
+func (b *MemoryBus) Publish(msg Invalidation) error {
+	b.subs.dispatch(msg)
+	return nil
+}
+
+// Subscribe registers handler with the bus.
+func (b *MemoryBus) Subscribe(handler func(Invalidation)) (func(), error) {
+	return b.subs.add(handler), nil
+}
@@ -0,0 +1,103 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+)
+
+// maxDatagramSize is the largest invalidation a MulticastInvalidator reads.
+const maxDatagramSize = 64 * 1024
+
+// MulticastInvalidator is an Invalidator which exchanges invalidations as
+// JSON datagrams over UDP multicast. Delivery is best-effort: lost datagrams
+// are not retransmitted, so items on other replicas may live until their
+// lifeSpan elapses.
+type MulticastInvalidator struct {
+	subs subscribers
+
+	group  *net.UDPAddr
+	listen *net.UDPConn
+	send   *net.UDPConn
+
+	closeOnce sync.Once
+}
+
+// NewMulticastInvalidator joins the multicast group address (e.g.
+// "239.0.0.1:9999") on the network interface ifi, or on the system default
+// interface if ifi is nil.
+func NewMulticastInvalidator(address string, ifi *net.Interface) (*MulticastInvalidator, error) {
+	group, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	listen, err := net.ListenMulticastUDP("udp", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	send, err := net.DialUDP("udp", nil, group)
+	if err != nil {
+		listen.Close()
+		return nil, err
+	}
+
+	m := &MulticastInvalidator{
+		group:  group,
+		listen: listen,
+		send:   send,
+	}
+	go m.receive()
+	return m, nil
+}
+
+// Publish sends msg to the multicast group.
+func (m *MulticastInvalidator) Publish(msg Invalidation) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = m.send.Write(b)
+	return err
+}
+
+// Subscribe registers handler for invalidations received from the group.
+// Messages published by this process are received as well; tables drop them
+// by their origin.
+func (m *MulticastInvalidator) Subscribe(handler func(Invalidation)) (func(), error) {
+	return m.subs.add(handler), nil
+}
+
+// Close leaves the multicast group.
+func (m *MulticastInvalidator) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		err = m.listen.Close()
+		if err1 := m.send.Close(); err == nil {
+			err = err1
+		}
+	})
+	return err
+}
+
+func (m *MulticastInvalidator) receive() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := m.listen.ReadFromUDP(buf)
+		if err != nil {
+			// The connection got closed.
+			return
+		}
+
+		var msg Invalidation
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			continue
+		}
+		m.subs.dispatch(msg)
+	}
+}
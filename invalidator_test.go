@@ -0,0 +1,156 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryBusInvalidation(t *testing.T) {
+	// two replicas of the same table, which New can't give us in one process
+	bus := NewMemoryBus()
+	a := newCacheTable("testInvalidation", time.Second)
+	b := newCacheTable("testInvalidation", time.Second)
+	other := newCacheTable("testInvalidationOther", time.Second)
+	for _, table := range []*CacheTable{a, b, other} {
+		if err := table.SetInvalidator(bus); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.Set(k, 0, v)
+	other.Set(k, 0, v)
+	a.Set(k, 0, v+"_new")
+	if b.Exists(k) {
+		t.Error("Set on one replica didn't invalidate the others")
+	}
+	if !a.Exists(k) {
+		t.Error("Replica invalidated its own key")
+	}
+	if !other.Exists(k) {
+		t.Error("Invalidation leaked into another table")
+	}
+
+	b.Set(k, 0, v)
+	a.Delete(k)
+	if b.Exists(k) {
+		t.Error("Delete on one replica didn't invalidate the others")
+	}
+
+	// flushes are only broadcast on request
+	b.Set(k, 0, v)
+	a.Flush()
+	if !b.Exists(k) {
+		t.Error("Flush got broadcast without being enabled")
+	}
+	a.EnableFlushBroadcast(true)
+	a.Flush()
+	if b.Count() != 0 {
+		t.Error("Flush didn't get broadcast")
+	}
+
+	// disconnected tables neither publish nor receive
+	b.SetInvalidator(nil)
+	b.Set(k, 0, v)
+	a.Set(k, 0, v)
+	if !b.Exists(k) {
+		t.Error("Disconnected table still receives invalidations")
+	}
+}
+
+func TestInvalidationDeduplication(t *testing.T) {
+	bus := NewMemoryBus()
+	table := newCacheTable("testInvalidationDedup", time.Second)
+	table.SetInvalidator(bus)
+
+	msg := Invalidation{Origin: "peer", ID: 1, Table: table.name, Key: k}
+	table.Set(k, 0, v)
+	bus.Publish(msg)
+	if table.Exists(k) {
+		t.Error("Invalidation wasn't applied")
+	}
+
+	// a retransmission of the same message must not delete the new value
+	table.Set(k, 0, v)
+	bus.Publish(msg)
+	if !table.Exists(k) {
+		t.Error("Duplicate invalidation was applied")
+	}
+}
+
+func TestWebhookInvalidation(t *testing.T) {
+	receiver := NewWebhookInvalidator()
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	a := newCacheTable("testWebhook", time.Second)
+	b := newCacheTable("testWebhook", time.Second)
+	a.SetInvalidator(NewWebhookInvalidator(srv.URL))
+	b.SetInvalidator(receiver)
+
+	b.Set(k, 0, v)
+	a.Set(k, 0, v)
+
+	for i := 0; i < 100 && b.Exists(k); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b.Exists(k) {
+		t.Error("Webhook invalidation wasn't applied")
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	received := make(chan struct{}, 10)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var m sync.Mutex
+	var errs []error
+	w := NewWebhookInvalidator(srv.URL)
+	w.QueueSize = 1
+	w.ErrorHandler = func(peer string, err error) {
+		m.Lock()
+		defer m.Unlock()
+		errs = append(errs, err)
+	}
+	defer w.Close()
+
+	// the first request blocks the peer's sender, the second one waits in
+	// the queue and the others get dropped
+	w.Publish(Invalidation{ID: 1})
+	<-received
+	for i := 2; i <= 10; i++ {
+		w.Publish(Invalidation{ID: uint64(i)})
+	}
+
+	m.Lock()
+	if len(errs) != 8 {
+		t.Errorf("Expected 8 dropped invalidations, got %d", len(errs))
+	}
+	for _, err := range errs {
+		if err != ErrQueueFull {
+			t.Errorf("Expected ErrQueueFull, got %v", err)
+		}
+	}
+	m.Unlock()
+
+	close(release)
+	<-received
+	w.Close()
+	if err := w.Publish(Invalidation{ID: 11}); err == nil {
+		t.Error("Publish succeeded after Close")
+	}
+}
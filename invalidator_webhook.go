@@ -0,0 +1,158 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// DefaultWebhookQueue is the number of invalidations queued per peer if
+// WebhookInvalidator.QueueSize isn't set.
+const DefaultWebhookQueue = 256
+
+// WebhookInvalidator is an Invalidator which POSTs invalidations as JSON to
+// the URLs of its peers. It also is the http.Handler receiving them, so every
+// replica mounts it at the URL it announced to the others.
+type WebhookInvalidator struct {
+	subs subscribers
+
+	// Peers are the URLs invalidations get posted to.
+	Peers []string
+	// Client is used to post invalidations. http.DefaultClient is used if nil.
+	Client *http.Client
+	// ErrorHandler, if set, gets called for every peer which could not be
+	// notified. Invalidations dropped for a full queue are reported with
+	// ErrQueueFull.
+	ErrorHandler func(peer string, err error)
+	// QueueSize is how many invalidations may wait for each peer. Zero
+	// means DefaultWebhookQueue.
+	QueueSize int
+
+	mu     sync.Mutex
+	queues map[string]chan []byte
+	closed bool
+}
+
+// NewWebhookInvalidator returns an Invalidator posting to peers.
+func NewWebhookInvalidator(peers ...string) *WebhookInvalidator {
+	return &WebhookInvalidator{Peers: peers}
+}
+
+// Publish posts msg to all peers. Requests are queued per peer and sent in
+// order by one goroutine each, so Publish never waits for slow peers. If the
+// queue of a peer is full, msg is dropped for it. Failures and drops are
+// reported to ErrorHandler.
+func (w *WebhookInvalidator) Publish(msg Invalidation) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return net.ErrClosed
+	}
+	var dropped []string
+	for _, peer := range w.Peers {
+		select {
+		case w.queue(peer) <- b:
+		default:
+			dropped = append(dropped, peer)
+		}
+	}
+	w.mu.Unlock()
+
+	if w.ErrorHandler != nil {
+		for _, peer := range dropped {
+			w.ErrorHandler(peer, ErrQueueFull)
+		}
+	}
+	return nil
+}
+
+// queue returns the queue of peer, starting its sender on first use. w.mu
+// must be locked.
+func (w *WebhookInvalidator) queue(peer string) chan []byte {
+	q, ok := w.queues[peer]
+	if ok {
+		return q
+	}
+	if w.queues == nil {
+		w.queues = make(map[string]chan []byte)
+	}
+	size := w.QueueSize
+	if size <= 0 {
+		size = DefaultWebhookQueue
+	}
+	q = make(chan []byte, size)
+	w.queues[peer] = q
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	go func() {
+		for b := range q {
+			w.post(client, peer, b)
+		}
+	}()
+	return q
+}
+
+// Close stops publishing. Invalidations already queued still get sent.
+func (w *WebhookInvalidator) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		for _, q := range w.queues {
+			close(q)
+		}
+	}
+	return nil
+}
+
+func (w *WebhookInvalidator) post(client *http.Client, peer string, b []byte) {
+	resp, err := client.Post(peer, "application/json", bytes.NewReader(b))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil && w.ErrorHandler != nil {
+		w.ErrorHandler(peer, err)
+	}
+}
+
+// Subscribe registers handler for invalidations received via ServeHTTP.
+func (w *WebhookInvalidator) Subscribe(handler func(Invalidation)) (func(), error) {
+	return w.subs.add(handler), nil
+}
+
+// ServeHTTP accepts invalidations posted by the peers.
+func (w *WebhookInvalidator) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg Invalidation
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxDatagramSize)).Decode(&msg); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.subs.dispatch(msg)
+	rw.WriteHeader(http.StatusNoContent)
+}
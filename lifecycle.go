@@ -0,0 +1,60 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+// Close shuts the table down: it stops the janitor, removes all items,
+// running the delete callbacks with ReasonClosed, ends the event
+// subscriptions and the invalidator subscription, and removes the table
+// from its registry, so New creates a fresh one under its name. Afterwards
+// methods returning an error return ErrTableClosed, the others do nothing.
+// Closing a closed table returns ErrTableClosed.
+func (table *CacheTable) Close() error {
+	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return ErrTableClosed
+	}
+	table.closed = true
+	items := table.items
+	table.items = make(map[interface{}]*CacheItem)
+	table.itemsShared = false
+	table.tagIndex = nil
+	table.ordered = nil
+	table.lru.reset()
+	table.stats.evict(ReasonClosed, len(items))
+	aboutToDeleteItem := table.aboutToDeleteItem
+	inv := table.invalidation
+	table.invalidation = nil
+	j := table.janitor
+	table.janitor = nil
+	table.Unlock()
+
+	if table.registry != nil {
+		table.registry.unregister(table)
+	}
+	if j != nil {
+		j.stop()
+	}
+	if inv != nil {
+		inv.cancel()
+	}
+	for _, item := range items {
+		table.notifyDelete(aboutToDeleteItem, item, ReasonClosed)
+	}
+	table.purgeDependencies()
+	for _, sub := range table.subscriptions() {
+		table.unsubscribe(sub)
+	}
+	return nil
+}
+
+// Closed reports whether the table was closed.
+func (table *CacheTable) Closed() bool {
+	table.RLock()
+	defer table.RUnlock()
+	return table.closed
+}
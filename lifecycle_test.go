@@ -0,0 +1,98 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	table := New("testClose", 10*time.Millisecond)
+	var reasons []EvictionReason
+	table.SetAboutToDeleteItemWithReasonCallback(func(item *CacheItem, reason EvictionReason) {
+		reasons = append(reasons, reason)
+	})
+	events, _ := table.Subscribe(EventFilter{})
+	table.Set(k, 0, v)
+	<-events
+
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reasons) != 1 || reasons[0] != ReasonClosed {
+		t.Errorf("Expected a callback with ReasonClosed, got %v", reasons)
+	}
+	if ev, ok := <-events; !ok || ev.Reason != ReasonClosed {
+		t.Errorf("Expected an evict event, got %v", ev)
+	}
+	if _, ok := <-events; ok {
+		t.Error("Subscription wasn't ended")
+	}
+	if _, ok := Lookup("testClose"); ok {
+		t.Error("Closed table is still registered")
+	}
+	if !table.Closed() || table.Close() != ErrTableClosed {
+		t.Error("Table doesn't report being closed")
+	}
+
+	if _, err := table.Get(k); err != ErrTableClosed {
+		t.Errorf("Expected ErrTableClosed from Get, got %v", err)
+	}
+	if _, err := table.Delete(k); err != ErrTableClosed {
+		t.Errorf("Expected ErrTableClosed from Delete, got %v", err)
+	}
+	if _, err := table.Increment(k, 1); err != ErrTableClosed {
+		t.Errorf("Expected ErrTableClosed from Increment, got %v", err)
+	}
+	if _, err := table.Take(k); err != ErrTableClosed {
+		t.Errorf("Expected ErrTableClosed from Take, got %v", err)
+	}
+	table.Set(k, 0, v)
+	if table.Add("new", 0, v) || table.Count() != 0 {
+		t.Error("Closed table accepted items")
+	}
+	if _, ok := table.Compute(k, func(old *CacheItem, exists bool) (interface{}, time.Duration, ComputeOp) {
+		return v, 0, ComputeSet
+	}); ok {
+		t.Error("Compute stored an item in a closed table")
+	}
+	events, cancel := table.Subscribe(EventFilter{})
+	if _, ok := <-events; ok {
+		t.Error("Subscription on a closed table wasn't ended")
+	}
+	cancel()
+
+	// the name is free for a new table
+	if New("testClose", time.Minute) == table {
+		t.Error("New returned the closed table")
+	}
+}
+
+func TestDrop(t *testing.T) {
+	// publishing only happens once per process, so use the map directly
+	PublishExpvar("cacherTest")
+	New("testDrop", time.Minute)
+	if defaultRegistry.expvarTables.Get("testDrop") == nil {
+		t.Fatal("Table wasn't published")
+	}
+
+	if err := Drop("testDrop"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range Tables() {
+		if name == "testDrop" {
+			t.Error("Dropped table is still listed")
+		}
+	}
+	if defaultRegistry.expvarTables.Get("testDrop") != nil {
+		t.Error("Dropped table is still published")
+	}
+	if err := Drop("testDrop"); err != ErrTableNotFound {
+		t.Errorf("Expected ErrTableNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// tableLogger is the logging configuration of a table. It is replaced as a
+// whole whenever a part of it changes.
+type tableLogger struct {
+	legacy *log.Logger
+	slog   *slog.Logger
+	redact func(key interface{}) interface{}
+}
+
+// SetStructuredLogger sets a structured logger to be used by this cache
+// table. Every record carries the table name as attribute "table" and,
+// where applicable, "key", "lifespan", "reason" and "hits". Adding and
+// deleting items is logged at debug level, failed loads and failed
+// invalidations are warnings. It replaces a logger set by SetLogger.
+func (table *CacheTable) SetStructuredLogger(logger *slog.Logger) {
+	table.updateLogger(func(l *tableLogger) {
+		l.legacy, l.slog = nil, logger
+	})
+}
+
+// SetKeyRedactor sets a function replacing keys before they get logged,
+// e.g. to keep sensitive keys out of the logs. Passing nil logs keys as
+// they are.
+func (table *CacheTable) SetKeyRedactor(f func(key interface{}) interface{}) {
+	table.updateLogger(func(l *tableLogger) {
+		l.redact = f
+	})
+}
+
+// RedactKey is a key redactor which hides keys completely.
+func RedactKey(key interface{}) interface{} {
+	return "[REDACTED]"
+}
+
+// HashKey is a key redactor which replaces keys with a short hash of their
+// formatted value, so log lines about the same key can still be correlated.
+func HashKey(key interface{}) interface{} {
+	sum := sha256.Sum256([]byte(fmt.Sprint(key)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (table *CacheTable) updateLogger(f func(l *tableLogger)) {
+	// The table lock serializes the setters; readers don't need it.
+	table.Lock()
+	defer table.Unlock()
+	l := new(tableLogger)
+	if old := table.logger.Load(); old != nil {
+		*l = *old
+	}
+	f(l)
+	table.logger.Store(l)
+}
+
+// keyAttr returns the "key" attribute for key, redacted if configured.
+func (table *CacheTable) keyAttr(key interface{}) slog.Attr {
+	if l := table.logger.Load(); l != nil && l.redact != nil {
+		key = l.redact(key)
+	}
+	return slog.Any("key", key)
+}
+
+// Internal logging method for convenience. The legacy logger gets every
+// message regardless of its level.
+func (table *CacheTable) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	l := table.logger.Load()
+	switch {
+	case l == nil:
+	case l.slog != nil:
+		attrs = append([]slog.Attr{slog.String("table", table.name)}, attrs...)
+		l.slog.LogAttrs(context.Background(), level, msg, attrs...)
+	case l.legacy != nil:
+		var b strings.Builder
+		b.WriteString(msg)
+		b.WriteString(" table=")
+		b.WriteString(table.name)
+		for _, a := range attrs {
+			b.WriteString(" " + a.String())
+		}
+		l.legacy.Println(b.String())
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStructuredLogger(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger := slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	table := newCacheTable("testStructuredLogger", time.Minute)
+	table.SetStructuredLogger(logger)
+	table.SetKeyRedactor(RedactKey)
+	table.SetDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+		return nil, 0, errors.New("boom")
+	})
+	table.Set("secret", time.Second, v)
+	table.Delete("secret")
+	table.Get("missing")
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(out)
+	for dec.More() {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %v", records)
+	}
+
+	add, del, load := records[0], records[1], records[2]
+	if add["level"] != "DEBUG" || add["table"] != "testStructuredLogger" || add["lifespan"] != float64(time.Second) {
+		t.Errorf("Unexpected add record %v", add)
+	}
+	if del["level"] != "DEBUG" || del["reason"] != "deleted" || del["hits"] != float64(0) {
+		t.Errorf("Unexpected delete record %v", del)
+	}
+	if load["level"] != "WARN" || load["error"] != "boom" {
+		t.Errorf("Unexpected load record %v", load)
+	}
+	for _, r := range records {
+		if r["key"] != "[REDACTED]" {
+			t.Errorf("Key wasn't redacted in %v", r)
+		}
+	}
+}
+
+func TestLoggerAttributes(t *testing.T) {
+	out := new(bytes.Buffer)
+	table := newCacheTable("testLoggerAttributes", time.Minute)
+	table.SetStructuredLogger(slog.New(slog.NewTextHandler(out, nil)))
+	// the legacy logger replaces the structured one
+	table.SetLogger(nil)
+	table.Set(k, 0, v)
+	if out.Len() != 0 {
+		t.Error("Replaced logger was used")
+	}
+
+	table.SetKeyRedactor(HashKey)
+	table.SetStructuredLogger(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	table.Set(k, 0, v)
+	if strings.Contains(out.String(), k) || !strings.Contains(out.String(), "key="+HashKey(k).(string)) {
+		t.Errorf("Key wasn't hashed in %q", out.String())
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"reflect"
+	"time"
+)
+
+// Increment atomically adds delta to the data of key and returns the new
+// value. The data must be of an integer type, which it keeps; like in Go
+// arithmetic, the value wraps around on overflow. The item keeps its lifespan
+// and isn't marked as accessed. Returns ErrKeyNotFound if the key doesn't
+// exist and ErrNotNumeric if its data isn't an integer.
+func (table *CacheTable) Increment(key interface{}, delta int64) (int64, error) {
+	var n int64
+	err := table.updateNumeric(key, false, 0, int64(0), func(v reflect.Value) bool {
+		return addInt(v, delta, &n)
+	})
+	return n, err
+}
+
+// Decrement atomically subtracts delta from the data of key. See Increment.
+func (table *CacheTable) Decrement(key interface{}, delta int64) (int64, error) {
+	return table.Increment(key, -delta)
+}
+
+// IncrementOrCreate is like Increment, but stores delta as int64 with the
+// given lifeSpan if the key doesn't exist.
+func (table *CacheTable) IncrementOrCreate(key interface{}, delta int64, lifeSpan time.Duration) (int64, error) {
+	n := delta
+	err := table.updateNumeric(key, true, lifeSpan, delta, func(v reflect.Value) bool {
+		return addInt(v, delta, &n)
+	})
+	return n, err
+}
+
+// IncrementFloat atomically adds delta to the data of key and returns the
+// new value. The data must be of a floating-point type, which it keeps. See
+// Increment.
+func (table *CacheTable) IncrementFloat(key interface{}, delta float64) (float64, error) {
+	var n float64
+	err := table.updateNumeric(key, false, 0, float64(0), func(v reflect.Value) bool {
+		return addFloat(v, delta, &n)
+	})
+	return n, err
+}
+
+// IncrementFloatOrCreate is like IncrementFloat, but stores delta as float64
+// with the given lifeSpan if the key doesn't exist.
+func (table *CacheTable) IncrementFloatOrCreate(key interface{}, delta float64, lifeSpan time.Duration) (float64, error) {
+	n := delta
+	err := table.updateNumeric(key, true, lifeSpan, delta, func(v reflect.Value) bool {
+		return addFloat(v, delta, &n)
+	})
+	return n, err
+}
+
+// updateNumeric runs add on the data of key, replacing the data with the
+// updated value. add reports false if the data has the wrong type. If create
+// is set, a missing key gets stored with initial as data instead.
+func (table *CacheTable) updateNumeric(key interface{}, create bool, lifeSpan time.Duration, initial interface{},
+	add func(v reflect.Value) bool) error {
+	// The table lock keeps Set, CompareAndSwap and Compute from replacing
+	// the item while its data changes in place.
+	table.Lock()
+	if table.closed {
+		table.Unlock()
+		return ErrTableClosed
+	}
+	item, ok := table.items[key]
+	if !ok {
+		if !create {
+			table.Unlock()
+			return ErrKeyNotFound
+		}
+		table.addInternal(table.newItem(key, lifeSpan, initial))
+		table.Unlock()
+		table.stats.set()
+		table.publishKey(key)
+		table.trim()
+		return nil
+	}
+
+	item.Lock()
+	v := reflect.ValueOf(item.data)
+	if !v.IsValid() {
+		item.Unlock()
+		table.Unlock()
+		return ErrNotNumeric
+	}
+	// A settable copy of the data, keeping its type.
+	nv := reflect.New(v.Type()).Elem()
+	nv.Set(v)
+	if !add(nv) {
+		item.Unlock()
+		table.Unlock()
+		return ErrNotNumeric
+	}
+	item.data = nv.Interface()
+	item.version = table.nextVersion()
+	item.Unlock()
+	table.Unlock()
+
+	table.emit(EventUpdate, item, 0)
+	table.publishKey(key)
+	return nil
+}
+
+// addInt adds delta to v if it is an integer, storing the result in n.
+func addInt(v reflect.Value, delta int64, n *int64) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(v.Int() + delta)
+		*n = v.Int()
+		return true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(v.Uint() + uint64(delta))
+		*n = int64(v.Uint())
+		return true
+	}
+	return false
+}
+
+// addFloat adds delta to v if it is a float, storing the result in n.
+func addFloat(v reflect.Value, delta float64, n *float64) bool {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(v.Float() + delta)
+		*n = v.Float()
+		return true
+	}
+	return false
+}
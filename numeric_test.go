@@ -0,0 +1,136 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIncrement(t *testing.T) {
+	type counter int16
+	table := newCacheTable("testIncrement", time.Minute)
+	for _, data := range [][2]interface{}{
+		{int(1), int(2)},
+		{int8(1), int8(2)},
+		{int32(1), int32(2)},
+		{uint(1), uint(2)},
+		{uint64(1), uint64(2)},
+		{counter(1), counter(2)},
+	} {
+		table.Set(k, 0, data[0])
+		if n, err := table.Increment(k, 2); err != nil || n != 3 {
+			t.Errorf("Increment of %T returned %d, %v", data[0], n, err)
+		}
+		if n, err := table.Decrement(k, 1); err != nil || n != 2 {
+			t.Errorf("Decrement of %T returned %d, %v", data[0], n, err)
+		}
+		if item, _ := table.Get(k); item.Data() != data[1] {
+			t.Errorf("Expected data %v of type %T, got %v of type %T", data[1], data[1], item.Data(), item.Data())
+		}
+	}
+
+	table.Set(k, 0, int8(127))
+	if n, _ := table.Increment(k, 1); n != -128 {
+		t.Errorf("Expected overflow to wrap around, got %d", n)
+	}
+
+	for _, data := range []interface{}{"1", 1.5, nil} {
+		table.Set(k, 0, data)
+		if _, err := table.Increment(k, 1); err != ErrNotNumeric {
+			t.Errorf("Expected ErrNotNumeric for %T, got %v", data, err)
+		}
+	}
+	if _, err := table.Increment("missing", 1); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestIncrementFloat(t *testing.T) {
+	table := newCacheTable("testIncrementFloat", time.Minute)
+	table.Set(k, 0, float32(1.5))
+	if n, err := table.IncrementFloat(k, 1); err != nil || n != 2.5 {
+		t.Errorf("IncrementFloat returned %v, %v", n, err)
+	}
+	if item, _ := table.Get(k); item.Data() != float32(2.5) {
+		t.Errorf("Expected float32 data, got %v of type %T", item.Data(), item.Data())
+	}
+
+	table.Set(k, 0, 1)
+	if _, err := table.IncrementFloat(k, 1); err != ErrNotNumeric {
+		t.Errorf("Expected ErrNotNumeric for int data, got %v", err)
+	}
+	if n, err := table.IncrementFloatOrCreate("new", 0.5, 0); err != nil || n != 0.5 {
+		t.Errorf("IncrementFloatOrCreate returned %v, %v", n, err)
+	}
+}
+
+func TestIncrementOrCreate(t *testing.T) {
+	table := newCacheTable("testIncrementOrCreate", time.Minute)
+	if n, err := table.IncrementOrCreate(k, 5, time.Hour); err != nil || n != 5 {
+		t.Errorf("IncrementOrCreate of a new key returned %d, %v", n, err)
+	}
+	item, _ := table.Get(k)
+	if item.LifeSpan() != time.Hour || item.Data() != int64(5) {
+		t.Errorf("Unexpected created item %v with lifespan %v", item.Data(), item.LifeSpan())
+	}
+
+	table.Set("short", time.Minute, 1)
+	if n, err := table.IncrementOrCreate("short", 1, time.Hour); err != nil || n != 2 {
+		t.Errorf("IncrementOrCreate of an existing key returned %d, %v", n, err)
+	}
+	if item, _ := table.Get("short"); item.LifeSpan() != time.Minute {
+		t.Error("Existing item didn't keep its lifespan")
+	}
+}
+
+func TestIncrementConcurrent(t *testing.T) {
+	table := newCacheTable("testIncrementConcurrent", time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				table.IncrementOrCreate(k, 1, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	if item, _ := table.Get(k); item.Data() != int64(1000) {
+		t.Errorf("Expected 1000 increments, got %v", item.Data())
+	}
+}
+
+func TestIncrementConcurrentCompute(t *testing.T) {
+	table := newCacheTable("testIncrementCompute", time.Minute)
+	table.Set(k, 0, 0)
+
+	const n = 50000
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			table.Increment(k, 1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			table.Compute(k, func(old *CacheItem, exists bool) (interface{}, time.Duration, ComputeOp) {
+				return old.Data().(int) + 1, 0, ComputeSet
+			})
+		}
+	}()
+	wg.Wait()
+
+	if item, _ := table.Peek(k); item.Data() != 2*n {
+		t.Errorf("Lost updates: expected %d, got %v", 2*n, item.Data())
+	}
+}
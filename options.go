@@ -0,0 +1,191 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DefaultCleanupInterval is the interval of the expiration checks of tables
+// created by NewWithOptions without WithCleanupInterval.
+const DefaultCleanupInterval = time.Second
+
+// Option configures a table created by NewWithOptions.
+type Option func(c *tableConfig)
+
+// tableConfig collects the options. The set flags tell which of the
+// comparable settings were given explicitly.
+type tableConfig struct {
+	cleanupInterval    time.Duration
+	cleanupIntervalSet bool
+	defaultTTL         time.Duration
+	defaultTTLSet      bool
+	capacity           int
+	capacitySet        bool
+
+	logger        *slog.Logger
+	clock         Clock
+	loader        func(key interface{}) (interface{}, time.Duration, error)
+	addedItem     []func(item *CacheItem)
+	aboutToDelete []func(item *CacheItem, reason EvictionReason)
+}
+
+// WithCleanupInterval sets the interval of the table's expiration checks,
+// which must be positive. DefaultCleanupInterval is used otherwise.
+func WithCleanupInterval(d time.Duration) Option {
+	return func(c *tableConfig) {
+		c.cleanupInterval, c.cleanupIntervalSet = d, true
+	}
+}
+
+// WithDefaultTTL sets the lifespan of items stored with a lifeSpan of 0.
+// Items stored with NoExpiration never expire. d must not be negative; 0
+// keeps items forever by default.
+func WithDefaultTTL(d time.Duration) Option {
+	return func(c *tableConfig) {
+		c.defaultTTL, c.defaultTTLSet = d, true
+	}
+}
+
+// WithCapacity limits the table to n items, which must not be negative.
+// Storing a new key in a full table evicts the least recently accessed item
+// with ReasonCapacity. 0 means unlimited.
+func WithCapacity(n int) Option {
+	return func(c *tableConfig) {
+		c.capacity, c.capacitySet = n, true
+	}
+}
+
+// WithLogger sets the structured logger of the table, see
+// SetStructuredLogger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *tableConfig) {
+		c.logger = logger
+	}
+}
+
+// WithClock sets the clock of the table, see Clock. SystemClock is used
+// otherwise.
+func WithClock(clock Clock) Option {
+	return func(c *tableConfig) {
+		c.clock = clock
+	}
+}
+
+// WithDataLoader sets the data-loader callback of the table, see
+// SetDataLoader.
+func WithDataLoader(f func(key interface{}) (interface{}, time.Duration, error)) Option {
+	return func(c *tableConfig) {
+		c.loader = f
+	}
+}
+
+// WithAddedItemCallback adds a callback triggered when an item gets added,
+// see AddAddedItemCallback.
+func WithAddedItemCallback(f func(item *CacheItem)) Option {
+	return func(c *tableConfig) {
+		c.addedItem = append(c.addedItem, f)
+	}
+}
+
+// WithAboutToDeleteItemCallback adds a callback triggered before an item
+// gets deleted, see AddAboutToDeleteItemWithReasonCallback.
+func WithAboutToDeleteItemCallback(f func(item *CacheItem, reason EvictionReason)) Option {
+	return func(c *tableConfig) {
+		c.aboutToDelete = append(c.aboutToDelete, f)
+	}
+}
+
+// NewWithOptions returns the table with the given name in the default
+// registry, creating it with opts if it doesn't exist yet. It returns an
+// error wrapping ErrInvalidConfig if an option has an invalid value.
+//
+// Reopening an existing table fails with an error wrapping
+// ErrConfigConflict if the cleanup interval, default TTL or capacity given
+// differ from the table's. Loggers, clocks, loaders and callbacks can't be
+// compared, so reopening a table with any of them fails as well instead of
+// silently ignoring them.
+func NewWithOptions(name string, opts ...Option) (*CacheTable, error) {
+	return defaultRegistry.NewWithOptions(name, opts...)
+}
+
+// NewWithOptions is like the package-level NewWithOptions for this
+// registry.
+func (r *Registry) NewWithOptions(name string, opts ...Option) (*CacheTable, error) {
+	c := tableConfig{cleanupInterval: DefaultCleanupInterval}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.tables[name]; ok {
+		if err := c.conflicts(t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	t := newCacheTable(name, c.cleanupInterval)
+	t.registry = r
+	t.defaultExpiration = c.defaultTTL
+	t.capacity = c.capacity
+	if c.capacity > 0 {
+		t.lru = newLRU()
+	}
+	if c.logger != nil {
+		t.logger.Store(&tableLogger{slog: c.logger})
+	}
+	if c.clock != nil {
+		t.clock = c.clock
+	}
+	t.loadData = c.loader
+	t.enableAutoLoad = c.loader != nil
+	t.addedItem = c.addedItem
+	t.aboutToDeleteItem = c.aboutToDelete
+	runJanitor(t, c.cleanupInterval)
+
+	r.tables[name] = t
+	r.publishTable(t)
+	return t, nil
+}
+
+func (c *tableConfig) validate() error {
+	if c.cleanupInterval <= 0 {
+		return fmt.Errorf("%w: cleanup interval %v is not positive", ErrInvalidConfig, c.cleanupInterval)
+	}
+	if c.defaultTTL < 0 {
+		return fmt.Errorf("%w: default TTL %v is negative", ErrInvalidConfig, c.defaultTTL)
+	}
+	if c.capacity < 0 {
+		return fmt.Errorf("%w: capacity %d is negative", ErrInvalidConfig, c.capacity)
+	}
+	return nil
+}
+
+// conflicts checks the explicitly given settings against the existing
+// table t.
+func (c *tableConfig) conflicts(t *CacheTable) error {
+	t.RLock()
+	defer t.RUnlock()
+	switch {
+	case c.cleanupIntervalSet && c.cleanupInterval != t.cleanupInterval:
+		return fmt.Errorf("%w: table %q has cleanup interval %v", ErrConfigConflict, t.name, t.cleanupInterval)
+	case c.defaultTTLSet && c.defaultTTL != t.defaultExpiration:
+		return fmt.Errorf("%w: table %q has default TTL %v", ErrConfigConflict, t.name, t.defaultExpiration)
+	case c.capacitySet && c.capacity != t.capacity:
+		return fmt.Errorf("%w: table %q has capacity %d", ErrConfigConflict, t.name, t.capacity)
+	case c.logger != nil || c.clock != nil || c.loader != nil || len(c.addedItem) > 0 || len(c.aboutToDelete) > 0:
+		return fmt.Errorf("%w: table %q exists, its logger, clock, loader and callbacks can't be changed", ErrConfigConflict, t.name)
+	}
+	return nil
+}
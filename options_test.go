@@ -0,0 +1,158 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher/cachertest"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	r := NewRegistry()
+	defer r.CloseAll()
+	var added int
+	table, err := r.NewWithOptions("testOptions",
+		WithCleanupInterval(time.Minute),
+		WithCapacity(10),
+		WithDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+			return v, 0, nil
+		}),
+		WithAddedItemCallback(func(item *CacheItem) { added++ }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.CleanupInterval() != time.Minute || table.Capacity() != 10 || !table.DataLoaderEnabled() {
+		t.Error("Options weren't applied")
+	}
+	table.Set(k, 0, v)
+	if added != 1 {
+		t.Error("Added callback wasn't called")
+	}
+
+	if again, err := r.NewWithOptions("testOptions", WithCapacity(10)); err != nil || again != table {
+		t.Errorf("Reopening with the same config failed: %v", err)
+	}
+	if again, err := r.NewWithOptions("testOptions"); err != nil || again != table {
+		t.Errorf("Reopening without options failed: %v", err)
+	}
+	for _, opt := range []Option{
+		WithCleanupInterval(time.Second),
+		WithCapacity(5),
+		WithDefaultTTL(time.Second),
+		WithAddedItemCallback(func(item *CacheItem) {}),
+	} {
+		if _, err := r.NewWithOptions("testOptions", opt); !errors.Is(err, ErrConfigConflict) {
+			t.Errorf("Expected ErrConfigConflict, got %v", err)
+		}
+	}
+	if _, err := r.NewWithOptions("testOptionsLegacy"); err != nil {
+		t.Fatal(err)
+	}
+	r.New("testOptionsNew", time.Hour)
+	if _, err := r.NewWithOptions("testOptionsNew", WithCleanupInterval(time.Minute)); !errors.Is(err, ErrConfigConflict) {
+		t.Errorf("Expected ErrConfigConflict for a table created by New, got %v", err)
+	}
+}
+
+func TestNewWithOptionsReload(t *testing.T) {
+	clock := cachertest.NewFakeClock(time.Now())
+	r := NewRegistry()
+	defer r.CloseAll()
+	table, err := r.NewWithOptions("testOptionsReload",
+		WithCleanupInterval(time.Second),
+		WithClock(clock),
+		WithDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+			return "reloaded", time.Minute, nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table.Set(k, 3*time.Second, v)
+	clock.Advance(time.Second)
+	table.Get(k)
+	clock.Advance(2 * time.Second)
+	item, err := table.Peek(k)
+	if err != nil || item.Data() != "reloaded" {
+		t.Errorf("Expired item wasn't reloaded: %v", err)
+	}
+}
+
+func TestNewWithOptionsValidation(t *testing.T) {
+	r := NewRegistry()
+	for _, opt := range []Option{
+		WithCleanupInterval(0),
+		WithCleanupInterval(-time.Second),
+		WithDefaultTTL(-time.Second),
+		WithCapacity(-1),
+	} {
+		if _, err := r.NewWithOptions("testOptionsInvalid", opt); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig, got %v", err)
+		}
+	}
+	if len(r.Tables()) != 0 {
+		t.Error("Invalid config created a table")
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	r := NewRegistry()
+	defer r.CloseAll()
+	table, err := r.NewWithOptions("testDefaultTTL", WithDefaultTTL(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.Set("default", 0, v)
+	table.Set("persistent", NoExpiration, v)
+	table.Set("explicit", time.Hour, v)
+	time.Sleep(time.Millisecond)
+	table.ExpirationCheck()
+	if table.Exists("default") || !table.Exists("persistent") || !table.Exists("explicit") {
+		t.Error("Default TTL wasn't applied to items without a lifespan only")
+	}
+	if ttl, _ := table.TTL("persistent"); ttl != NoExpiration {
+		t.Errorf("Expected NoExpiration, got %v", ttl)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	r := NewRegistry()
+	defer r.CloseAll()
+	table, err := r.NewWithOptions("testCapacity", WithCapacity(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evicted []interface{}
+	table.SetAboutToDeleteItemWithReasonCallback(func(item *CacheItem, reason EvictionReason) {
+		if reason == ReasonCapacity {
+			evicted = append(evicted, item.Key())
+		}
+	})
+
+	table.Set("a", 0, v)
+	table.Set("b", 0, v)
+	table.Set("a", 0, v)
+	if table.Count() != 2 || len(evicted) != 0 {
+		t.Error("Replacing an item evicted another one")
+	}
+	table.Get("b")
+	table.Set("c", 0, v)
+	if table.Count() != 2 || len(evicted) != 1 || evicted[0] != "a" {
+		t.Errorf("Expected a to be evicted, got %v", evicted)
+	}
+
+	table.SetMany(map[interface{}]interface{}{"d": v, "e": v, "f": v}, 0)
+	if table.Count() != 2 || len(evicted) != 4 {
+		t.Errorf("Expected the table to be trimmed to capacity, got %d items", table.Count())
+	}
+	if n := table.Stats().Snapshot().Evictions[ReasonCapacity]; n != 4 {
+		t.Errorf("Expected 4 capacity evictions, got %d", n)
+	}
+}
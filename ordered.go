@@ -0,0 +1,116 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+// Page is a part of the result of an ordered scan.
+type Page struct {
+	// Items are the items of the page in key order.
+	Items []*CacheItem
+	// Next is the cursor for the next page, or empty if this is the last
+	// one.
+	Next string
+}
+
+// EnableOrderedIndex makes the table keep its string keys in order, which
+// enables Range, Prefix and DeleteRange. Keys of other types aren't
+// indexed. The index costs memory and time on every add and delete.
+func (table *CacheTable) EnableOrderedIndex() {
+	table.Lock()
+	defer table.Unlock()
+	if table.ordered != nil {
+		return
+	}
+	table.ordered = newSkiplist()
+	for key := range table.items {
+		if s, ok := key.(string); ok {
+			table.ordered.insert(s)
+		}
+	}
+}
+
+// Range returns the items with string keys in [start, end) in key order.
+// An empty end means no upper bound. If limit is positive, at most limit
+// items are returned per page; pass the Next cursor of a page to get the
+// following one. The table is only locked while a page is collected, so
+// changes between pages show up in later pages. Returns
+// ErrNoOrderedIndex unless EnableOrderedIndex was called.
+func (table *CacheTable) Range(start, end, cursor string, limit int) (Page, error) {
+	if cursor != "" {
+		start = cursor
+	}
+
+	table.RLock()
+	defer table.RUnlock()
+	if table.ordered == nil {
+		return Page{}, ErrNoOrderedIndex
+	}
+
+	var page Page
+	table.ordered.scan(start, end, func(key string) bool {
+		if limit > 0 && len(page.Items) == limit {
+			page.Next = key
+			return false
+		}
+		page.Items = append(page.Items, table.items[key])
+		return true
+	})
+	return page, nil
+}
+
+// Prefix is like Range for the keys starting with prefix.
+func (table *CacheTable) Prefix(prefix, cursor string, limit int) (Page, error) {
+	return table.Range(prefix, prefixEnd(prefix), cursor, limit)
+}
+
+// DeleteRange deletes the items with string keys in [start, end) and
+// returns them by key. An empty end means no upper bound. Delete callbacks
+// run after the items were removed.
+func (table *CacheTable) DeleteRange(start, end string) (map[interface{}]*CacheItem, error) {
+	var err error
+	deleted := table.removeMany(ReasonDeleted, func() []interface{} {
+		if table.ordered == nil {
+			err = ErrNoOrderedIndex
+			return nil
+		}
+		var keys []interface{}
+		table.ordered.scan(start, end, func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	})
+	return deleted, err
+}
+
+// prefixEnd returns the smallest string greater than all strings starting
+// with prefix, or empty if there is none.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+// indexInternal adds key to the ordered index, if any. Do not run it unless
+// the table-mutex is locked.
+func (table *CacheTable) indexInternal(key interface{}) {
+	if s, ok := key.(string); ok && table.ordered != nil {
+		table.ordered.insert(s)
+	}
+}
+
+// unindexInternal removes key from the ordered index, if any. Do not run it
+// unless the table-mutex is locked.
+func (table *CacheTable) unindexInternal(key interface{}) {
+	if s, ok := key.(string); ok && table.ordered != nil {
+		table.ordered.remove(s)
+	}
+}
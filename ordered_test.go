@@ -0,0 +1,135 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func pageKeys(p Page) []string {
+	var keys []string
+	for _, item := range p.Items {
+		keys = append(keys, item.Key().(string))
+	}
+	return keys
+}
+
+func TestSkiplist(t *testing.T) {
+	s := newSkiplist()
+	set := make(map[string]bool)
+	for i := 0; i < 2000; i++ {
+		key := strconv.Itoa(rand.Intn(500))
+		if rand.Intn(3) == 0 {
+			if s.remove(key) != set[key] {
+				t.Fatalf("remove(%s) disagrees with the reference", key)
+			}
+			delete(set, key)
+		} else {
+			if s.insert(key) == set[key] {
+				t.Fatalf("insert(%s) disagrees with the reference", key)
+			}
+			set[key] = true
+		}
+	}
+
+	var want, got []string
+	for key := range set {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+	s.scan("", "", func(key string) bool {
+		got = append(got, key)
+		return true
+	})
+	if !reflect.DeepEqual(got, want) || s.len != len(want) {
+		t.Errorf("Skiplist holds %d keys, expected %d", len(got), len(want))
+	}
+}
+
+func TestRange(t *testing.T) {
+	table := newCacheTable("testRange", time.Minute)
+	table.Set("b", 0, v)
+	table.Set(1, 0, v)
+	if _, err := table.Range("", "", "", 0); err != ErrNoOrderedIndex {
+		t.Errorf("Expected ErrNoOrderedIndex, got %v", err)
+	}
+	table.EnableOrderedIndex()
+	for _, key := range []string{"a", "c", "d", "e"} {
+		table.Set(key, 0, v)
+	}
+
+	page, _ := table.Range("b", "e", "", 0)
+	if keys := pageKeys(page); !reflect.DeepEqual(keys, []string{"b", "c", "d"}) || page.Next != "" {
+		t.Errorf("Unexpected range %v", keys)
+	}
+
+	var all []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		page, err := table.Range("", "", cursor, 2)
+		if err != nil || pages > 3 {
+			t.Fatalf("Paging failed: %v", err)
+		}
+		all = append(all, pageKeys(page)...)
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+		// changes between pages show up
+		if cursor == "c" {
+			table.Delete("d")
+		}
+	}
+	if !reflect.DeepEqual(all, []string{"a", "b", "c", "e"}) {
+		t.Errorf("Unexpected pages %v", all)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	table := newCacheTable("testPrefix", time.Minute)
+	table.EnableOrderedIndex()
+	for _, key := range []string{"user:1", "user:2", "users", "user;", "session:1", "\xff\xff"} {
+		table.Set(key, 0, v)
+	}
+	page, _ := table.Prefix("user:", "", 0)
+	if keys := pageKeys(page); !reflect.DeepEqual(keys, []string{"user:1", "user:2"}) {
+		t.Errorf("Unexpected prefix scan %v", keys)
+	}
+	page, _ = table.Prefix("\xff", "", 0)
+	if keys := pageKeys(page); len(keys) != 1 {
+		t.Errorf("Unexpected prefix scan %v", keys)
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	table := newCacheTable("testDeleteRange", time.Minute)
+	table.EnableOrderedIndex()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		table.Set(key, 0, v)
+	}
+	table.Set("expiring", time.Nanosecond, v)
+
+	deleted, err := table.DeleteRange("b", "d")
+	if err != nil || len(deleted) != 2 || table.Exists("b") || table.Exists("c") {
+		t.Errorf("DeleteRange returned %v, %v", deleted, err)
+	}
+	time.Sleep(time.Millisecond)
+	table.ExpirationCheck()
+	page, _ := table.Range("", "", "", 0)
+	if keys := pageKeys(page); !reflect.DeepEqual(keys, []string{"a", "d"}) {
+		t.Errorf("Index wasn't maintained: %v", keys)
+	}
+	table.Flush()
+	if page, _ := table.Range("", "", "", 0); len(page.Items) != 0 {
+		t.Errorf("Index wasn't flushed: %v", pageKeys(page))
+	}
+}
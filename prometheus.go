@@ -0,0 +1,141 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tableMetrics is what gets exported about a single table.
+type tableMetrics struct {
+	name  string
+	count int
+	stats StatsSnapshot
+}
+
+// WriteMetrics writes the metrics of all tables in the cache to w, using
+// the Prometheus text exposition format. Every sample is labelled with the
+// name of its table.
+func WriteMetrics(w io.Writer) error {
+	return defaultRegistry.WriteMetrics(w)
+}
+
+// WriteMetrics is like the package-level WriteMetrics for the tables of
+// this registry.
+func (r *Registry) WriteMetrics(w io.Writer) error {
+	var tables []tableMetrics
+	for _, name := range r.Tables() {
+		if t, ok := r.Lookup(name); ok {
+			tables = append(tables, tableMetrics{name, t.Count(), t.Stats().Snapshot()})
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	family := func(name, typ, help string) {
+		bw.WriteString("# HELP " + name + " " + help + "\n")
+		bw.WriteString("# TYPE " + name + " " + typ + "\n")
+	}
+	sample := func(name string, labels []string, value float64) {
+		bw.WriteString(name)
+		bw.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			bw.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		bw.WriteString("} ")
+		bw.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+		bw.WriteByte('\n')
+	}
+	counter := func(name, help string, value func(s StatsSnapshot) int64) {
+		family(name, "counter", help)
+		for _, t := range tables {
+			sample(name, []string{"table", t.name}, float64(value(t.stats)))
+		}
+	}
+
+	family("cacher_items", "gauge", "Number of items currently stored in the table.")
+	for _, t := range tables {
+		sample("cacher_items", []string{"table", t.name}, float64(t.count))
+	}
+	counter("cacher_hits_total", "Number of Get calls which found their key.",
+		func(s StatsSnapshot) int64 { return s.Hits })
+	counter("cacher_misses_total", "Number of Get calls which didn't find their key.",
+		func(s StatsSnapshot) int64 { return s.Misses })
+	counter("cacher_sets_total", "Number of items stored by Set and Add.",
+		func(s StatsSnapshot) int64 { return s.Sets })
+	counter("cacher_deletes_total", "Number of items removed by Delete.",
+		func(s StatsSnapshot) int64 { return s.Deletes })
+	counter("cacher_expirations_total", "Number of items found expired by the expiration check.",
+		func(s StatsSnapshot) int64 { return s.Expirations })
+	counter("cacher_dropped_events_total", "Number of events discarded because a subscriber's buffer was full.",
+		func(s StatsSnapshot) int64 { return s.DroppedEvents })
+
+	family("cacher_loads_total", "counter", "Number of data-loader calls by result.")
+	for _, t := range tables {
+		sample("cacher_loads_total", []string{"table", t.name, "result", "success"}, float64(t.stats.LoadSuccesses))
+		sample("cacher_loads_total", []string{"table", t.name, "result", "failure"}, float64(t.stats.LoadFailures))
+	}
+
+	family("cacher_evictions_total", "counter", "Number of items which left the table by reason.")
+	for _, t := range tables {
+		for r := EvictionReason(0); r < numEvictionReasons; r++ {
+			sample("cacher_evictions_total", []string{"table", t.name, "reason", r.String()}, float64(t.stats.Evictions[r]))
+		}
+	}
+
+	family("cacher_load_duration_seconds", "histogram", "Latency of data-loader calls.")
+	for _, t := range tables {
+		var cumulative int64
+		for i, bound := range loadLatencyBuckets {
+			cumulative += t.stats.LoadLatency[i]
+			sample("cacher_load_duration_seconds_bucket", []string{"table", t.name, "le", formatSeconds(bound)}, float64(cumulative))
+		}
+		sample("cacher_load_duration_seconds_bucket", []string{"table", t.name, "le", "+Inf"}, float64(t.stats.Loads))
+		sample("cacher_load_duration_seconds_sum", []string{"table", t.name}, t.stats.LoadTime.Seconds())
+		sample("cacher_load_duration_seconds_count", []string{"table", t.name}, float64(t.stats.Loads))
+	}
+
+	family("cacher_janitor_duration_seconds", "summary", "Duration of expiration checks.")
+	for _, t := range tables {
+		sample("cacher_janitor_duration_seconds_sum", []string{"table", t.name}, t.stats.JanitorTime.Seconds())
+		sample("cacher_janitor_duration_seconds_count", []string{"table", t.name}, float64(t.stats.JanitorRuns))
+	}
+
+	return bw.Flush()
+}
+
+// MetricsHandler returns an http.Handler serving WriteMetrics, to be
+// scraped by Prometheus.
+func MetricsHandler() http.Handler {
+	return defaultRegistry.MetricsHandler()
+}
+
+// MetricsHandler returns an http.Handler serving the WriteMetrics of this
+// registry.
+func (r *Registry) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteMetrics(w)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
@@ -0,0 +1,51 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	r := NewRegistry()
+	defer r.CloseAll()
+	table := r.New("testMetrics\"", time.Minute)
+	table.SetDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+		return v, 0, nil
+	})
+	table.Set(k, 0, v)
+	table.Get(k)
+	table.Get("loaded")
+	table.Delete(k)
+	table.ExpirationCheck()
+
+	rec := httptest.NewRecorder()
+	r.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+
+	for _, line := range []string{
+		`# TYPE cacher_hits_total counter`,
+		`cacher_items{table="testMetrics\""} 1`,
+		`cacher_hits_total{table="testMetrics\""} 1`,
+		`cacher_misses_total{table="testMetrics\""} 1`,
+		`cacher_loads_total{table="testMetrics\"",result="success"} 1`,
+		`cacher_evictions_total{table="testMetrics\"",reason="deleted"} 1`,
+		`cacher_load_duration_seconds_bucket{table="testMetrics\"",le="+Inf"} 1`,
+		`cacher_load_duration_seconds_count{table="testMetrics\""} 1`,
+		`cacher_janitor_duration_seconds_count{table="testMetrics\""} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Metrics lack %s", line)
+		}
+	}
+	if !strings.Contains(out, `cacher_load_duration_seconds_bucket{table="testMetrics\"",le="0.001"} 1`) {
+		t.Error("Fast load wasn't counted in the first bucket")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	a := NewRegistry()
+	b := NewRegistry()
+	ta := a.New("testRegistry", time.Minute)
+	tb := b.New("testRegistry", time.Minute)
+	if ta == tb {
+		t.Fatal("Registries share a table")
+	}
+	if a.New("testRegistry", time.Minute) != ta {
+		t.Error("New didn't return the existing table")
+	}
+	if _, ok := Lookup("testRegistry"); ok {
+		t.Error("Table leaked into the default registry")
+	}
+	if names := a.Tables(); len(names) != 1 || names[0] != "testRegistry" {
+		t.Errorf("Unexpected tables %v", names)
+	}
+
+	ta.Set(k, 0, v)
+	var buf bytes.Buffer
+	if err := a.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `cacher_items{table="testRegistry"} 1`) {
+		t.Errorf("Metrics are missing the table:\n%s", buf.String())
+	}
+
+	if err := a.Drop("testRegistry"); err != nil {
+		t.Error(err)
+	}
+	if a.Drop("testRegistry") != ErrTableNotFound {
+		t.Error("Dropped table is still registered")
+	}
+	if tb.Closed() {
+		t.Error("Dropping closed the table of another registry")
+	}
+}
+
+func TestRegistryCloseAll(t *testing.T) {
+	r := NewRegistry()
+	t1 := r.New("one", time.Minute)
+	t2 := r.New("two", time.Minute)
+	r.CloseAll()
+	if !t1.Closed() || !t2.Closed() || len(r.Tables()) != 0 {
+		t.Error("CloseAll left tables open")
+	}
+}
+
+func TestRegistryDependency(t *testing.T) {
+	r := NewRegistry()
+	defer r.CloseAll()
+	base := r.New("base", time.Minute)
+	derived := r.New("derived", time.Minute)
+	base.Set(k, 0, v)
+	derived.Set(k, 0, v)
+	if err := derived.AddDependency(k, Dependency{Table: "base", Key: k}); err != nil {
+		t.Fatal(err)
+	}
+	if err := derived.AddDependency(k, Dependency{Table: "missing", Key: k}); err != ErrTableNotFound {
+		t.Errorf("Expected ErrTableNotFound, got %v", err)
+	}
+
+	base.Delete(k)
+	if derived.Exists(k) {
+		t.Error("Dependency didn't cascade within the registry")
+	}
+}
@@ -0,0 +1,484 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+const (
+	// maxKeyLength is the longest key memcached accepts.
+	maxKeyLength = 250
+	// maxItemSize is the largest data block accepted by storage commands.
+	maxItemSize = 1024 * 1024
+	// relativeExptimeLimit is the largest exptime interpreted as seconds
+	// from now rather than a unix timestamp (30 days).
+	relativeExptimeLimit = 60 * 60 * 24 * 30
+)
+
+// errQuit ends a connection on the quit command.
+var errQuit = errors.New("quit")
+
+// conn is a single client connection.
+type conn struct {
+	server *Server
+	r      *bufio.Reader
+	w      *bufio.Writer
+}
+
+// handle reads and answers one command.
+func (c *conn) handle() error {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		c.w.WriteString("CLIENT_ERROR line too long\r\n")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	args := strings.Fields(string(line))
+	if len(args) == 0 {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "get":
+		return c.get(args, false)
+	case "gets":
+		return c.get(args, true)
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return c.store(cmd, args)
+	case "delete":
+		return c.delete(args)
+	case "incr", "decr":
+		return c.incr(cmd, args)
+	case "touch":
+		return c.touch(args)
+	case "flush_all":
+		return c.flushAll(args)
+	case "stats":
+		return c.stats(args)
+	case "version":
+		c.w.WriteString("VERSION " + Version + "\r\n")
+	case "verbosity":
+		c.reply(noreply(args), "OK")
+	case "quit":
+		return errQuit
+	default:
+		c.w.WriteString("ERROR\r\n")
+	}
+	return nil
+}
+
+// reply writes msg unless the client asked for no reply.
+func (c *conn) reply(quiet bool, msg string) {
+	if !quiet {
+		c.w.WriteString(msg)
+		c.w.WriteString("\r\n")
+	}
+}
+
+func (c *conn) clientError(msg string) {
+	c.w.WriteString("CLIENT_ERROR " + msg + "\r\n")
+}
+
+// noreply reports whether the last argument is "noreply".
+func noreply(args []string) bool {
+	return len(args) > 0 && args[len(args)-1] == "noreply"
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// lifeSpan converts a memcached exptime into a lifeSpan, cacher.NoExpiration
+// for 0. It returns false if the item is expired already.
+func lifeSpan(exptime int64) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return cacher.NoExpiration, true
+	case exptime < 0:
+		return 0, false
+	case exptime <= relativeExptimeLimit:
+		return time.Duration(exptime) * time.Second, true
+	}
+	d := time.Until(time.Unix(exptime, 0))
+	return d, d > 0
+}
+
+// remaining returns the time item has left to live, cacher.NoExpiration if
+// it never expires.
+func remaining(item *cacher.CacheItem) (time.Duration, bool) {
+	expiresAt := item.ExpiresAt()
+	if expiresAt.IsZero() {
+		return cacher.NoExpiration, true
+	}
+	d := time.Until(expiresAt)
+	return d, d > 0
+}
+
+// itemOf converts the data of a cached item into its protocol form.
+func itemOf(ci *cacher.CacheItem) *Item {
+	switch d := ci.Data().(type) {
+	case *Item:
+		return d
+	case []byte:
+		return &Item{Value: d}
+	case string:
+		return &Item{Value: []byte(d)}
+	case nil:
+		return &Item{}
+	default:
+		return &Item{Value: []byte(fmt.Sprint(d))}
+	}
+}
+
+// lookup returns the live item stored under key without invoking the
+// table's data loader or counting an access.
+func (s *Server) lookup(key string) (*cacher.CacheItem, bool) {
+	item, err := s.table.Peek(key)
+	if err != nil {
+		return nil, false
+	}
+	if _, ok := remaining(item); !ok {
+		return nil, false
+	}
+	return item, true
+}
+
+// nextCAS returns a new unique value for the cas command.
+func (s *Server) nextCAS() uint64 {
+	return atomic.AddUint64(&s.cas, 1)
+}
+
+func (c *conn) get(keys []string, withCAS bool) error {
+	if len(keys) == 0 {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+
+	s := c.server
+	for _, key := range keys {
+		atomic.AddUint64(&s.stats.cmdGet, 1)
+		ci, err := s.table.Get(key)
+		if err == nil {
+			if _, ok := remaining(ci); !ok {
+				err = cacher.ErrKeyNotFound
+			}
+		}
+		hit(err == nil, &s.stats.getHits, &s.stats.getMisses)
+		if err != nil {
+			continue
+		}
+
+		item := itemOf(ci)
+		fmt.Fprintf(c.w, "VALUE %s %d %d", key, item.Flags, len(item.Value))
+		if withCAS {
+			fmt.Fprintf(c.w, " %d", item.CAS)
+		}
+		c.w.WriteString("\r\n")
+		c.w.Write(item.Value)
+		c.w.WriteString("\r\n")
+	}
+	c.w.WriteString("END\r\n")
+	return nil
+}
+
+func (c *conn) store(cmd string, args []string) error {
+	n := 4
+	if cmd == "cas" {
+		n = 5
+	}
+	quiet := len(args) == n+1 && args[n] == "noreply"
+	if len(args) != n && !quiet {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	var unique uint64
+	var err4 error
+	if cmd == "cas" {
+		unique, err4 = strconv.ParseUint(args[4], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+		c.clientError("bad command line format")
+		return nil
+	}
+
+	if size > maxItemSize {
+		// Swallow the data block so the connection stays usable.
+		if _, err := c.r.Discard(size + 2); err != nil {
+			return err
+		}
+		c.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return nil
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		c.clientError("bad data chunk")
+		return nil
+	}
+	data = data[:size]
+	if !validKey(key) {
+		c.clientError("bad command line format")
+		return nil
+	}
+
+	s := c.server
+	atomic.AddUint64(&s.stats.cmdSet, 1)
+	unlock := s.lock(key)
+	defer unlock()
+
+	old, exists := s.lookup(key)
+	item := &Item{Value: data, Flags: uint32(flags), CAS: s.nextCAS()}
+	life, live := lifeSpan(exptime)
+
+	switch cmd {
+	case "add":
+		if exists {
+			c.reply(quiet, "NOT_STORED")
+			return nil
+		}
+	case "replace":
+		if !exists {
+			c.reply(quiet, "NOT_STORED")
+			return nil
+		}
+	case "append", "prepend":
+		if !exists {
+			c.reply(quiet, "NOT_STORED")
+			return nil
+		}
+		prev := itemOf(old)
+		value := make([]byte, 0, len(prev.Value)+len(data))
+		if cmd == "append" {
+			value = append(append(value, prev.Value...), data...)
+		} else {
+			value = append(append(value, data...), prev.Value...)
+		}
+		item.Value = value
+		item.Flags = prev.Flags
+		// The item keeps its expiration.
+		life, live = remaining(old)
+	case "cas":
+		hit(exists, &s.stats.casHits, &s.stats.casMisses)
+		if !exists {
+			c.reply(quiet, "NOT_FOUND")
+			return nil
+		}
+		if itemOf(old).CAS != unique {
+			atomic.AddUint64(&s.stats.casBadval, 1)
+			c.reply(quiet, "EXISTS")
+			return nil
+		}
+	}
+
+	if live {
+		s.table.Set(key, life, item)
+	} else if exists {
+		s.table.Delete(key)
+	}
+	c.reply(quiet, "STORED")
+	return nil
+}
+
+func (c *conn) delete(args []string) error {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	// Old clients may send a zero hold time.
+	if len(args) == 2 && args[1] == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		c.clientError("bad command line format.  Usage: delete <key> [noreply]")
+		return nil
+	}
+
+	s := c.server
+	key := args[0]
+	unlock := s.lock(key)
+	defer unlock()
+
+	_, exists := s.lookup(key)
+	hit(exists, &s.stats.deleteHits, &s.stats.deleteMiss)
+	if !exists {
+		c.reply(quiet, "NOT_FOUND")
+		return nil
+	}
+	s.table.Delete(key)
+	c.reply(quiet, "DELETED")
+	return nil
+}
+
+func (c *conn) incr(cmd string, args []string) error {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.clientError("invalid numeric delta argument")
+		return nil
+	}
+
+	s := c.server
+	key := args[0]
+	hits, misses := &s.stats.incrHits, &s.stats.incrMisses
+	if cmd == "decr" {
+		hits, misses = &s.stats.decrHits, &s.stats.decrMisses
+	}
+	unlock := s.lock(key)
+	defer unlock()
+
+	old, exists := s.lookup(key)
+	var life time.Duration
+	if exists {
+		// The item keeps its expiration, unless it expired meanwhile.
+		life, exists = remaining(old)
+	}
+	hit(exists, hits, misses)
+	if !exists {
+		c.reply(quiet, "NOT_FOUND")
+		return nil
+	}
+
+	prev := itemOf(old)
+	value, err := strconv.ParseUint(string(prev.Value), 10, 64)
+	if err != nil {
+		c.clientError("cannot increment or decrement non-numeric value")
+		return nil
+	}
+	if cmd == "incr" {
+		// Incrementing wraps around at 64 bit.
+		value += delta
+	} else if delta > value {
+		// Decrementing stops at zero.
+		value = 0
+	} else {
+		value -= delta
+	}
+
+	formatted := strconv.FormatUint(value, 10)
+	s.table.Set(key, life, &Item{Value: []byte(formatted), Flags: prev.Flags, CAS: s.nextCAS()})
+	c.reply(quiet, formatted)
+	return nil
+}
+
+func (c *conn) touch(args []string) error {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.clientError("invalid exptime argument")
+		return nil
+	}
+
+	s := c.server
+	key := args[0]
+	atomic.AddUint64(&s.stats.cmdTouch, 1)
+	unlock := s.lock(key)
+	defer unlock()
+
+	_, exists := s.lookup(key)
+	hit(exists, &s.stats.touchHits, &s.stats.touchMisses)
+	if !exists {
+		c.reply(quiet, "NOT_FOUND")
+		return nil
+	}
+
+	if life, live := lifeSpan(exptime); live {
+		s.table.Touch(key, life)
+	} else {
+		s.table.Delete(key)
+	}
+	c.reply(quiet, "TOUCHED")
+	return nil
+}
+
+func (c *conn) flushAll(args []string) error {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	var delay int64
+	if len(args) > 1 {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+	if len(args) == 1 {
+		var err error
+		if delay, err = strconv.ParseInt(args[0], 10, 64); err != nil || delay < 0 {
+			c.clientError("invalid exptime argument")
+			return nil
+		}
+	}
+
+	s := c.server
+	atomic.AddUint64(&s.stats.cmdFlush, 1)
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, s.table.Flush)
+	} else {
+		s.table.Flush()
+	}
+	c.reply(quiet, "OK")
+	return nil
+}
+
+func (c *conn) stats(args []string) error {
+	switch {
+	case len(args) == 0:
+		var b strings.Builder
+		c.server.writeStats(&b)
+		c.w.WriteString(b.String())
+	case len(args) == 1 && args[0] == "reset":
+		c.server.stats.reset()
+		c.w.WriteString("RESET\r\n")
+	default:
+		c.w.WriteString("ERROR\r\n")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+// Package memcache serves a cacher.CacheTable over TCP with the memcached
+// text protocol, so existing memcached clients can talk to an embedded cache.
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+// Version is reported by the version and stats commands.
+const Version = "1.6.0-cacher"
+
+// ErrServerClosed is returned by Serve after Close was called.
+var ErrServerClosed = errors.New("memcache: Server closed")
+
+// Item is the value stored in the table for keys written via the protocol.
+// Data of any other type found in the table is served with zero flags, as
+// its bytes ([]byte, string) or as its fmt.Sprint representation.
+type Item struct {
+	// Value is the stored data block.
+	Value []byte
+	// Flags are the opaque client flags.
+	Flags uint32
+	// CAS is the unique value returned by gets and checked by cas.
+	CAS uint64
+}
+
+// lockStripes is the number of mutexes serializing read-modify-write
+// commands on the same key.
+const lockStripes = 64
+
+// Server serves a single cache table over the memcached text protocol.
+type Server struct {
+	// Last cas unique handed out, accessed atomically.
+	cas   uint64
+	stats *counters
+	table *cacher.CacheTable
+	locks [lockStripes]sync.Mutex
+
+	started time.Time
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// NewServer returns a server for table.
+func NewServer(table *cacher.CacheTable) *Server {
+	return &Server{
+		stats:     new(counters),
+		table:     table,
+		started:   time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it fails or the server gets closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go s.serveConn(c)
+	}
+}
+
+// Close stops all listeners and closes all open connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+
+	var err error
+	for l := range s.listeners {
+		if err1 := l.Close(); err == nil {
+			err = err1
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	atomic.AddUint64(&s.stats.currConnections, 1)
+	atomic.AddUint64(&s.stats.totalConnections, 1)
+	defer func() {
+		atomic.AddUint64(&s.stats.currConnections, ^uint64(0))
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	conn := &conn{
+		server: s,
+		r:      bufio.NewReader(c),
+		w:      bufio.NewWriter(c),
+	}
+	for {
+		err := conn.handle()
+		// Pipelined commands get answered in one write.
+		if conn.r.Buffered() == 0 || err != nil {
+			if err1 := conn.w.Flush(); err == nil {
+				err = err1
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// lock serializes commands on key.
+func (s *Server) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	m := &s.locks[h.Sum32()%lockStripes]
+	m.Lock()
+	return m.Unlock
+}
@@ -0,0 +1,188 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+type client struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+func startServer(t *testing.T, name string) (*cacher.CacheTable, *client) {
+	table := cacher.New(name, time.Second)
+	table.Flush()
+	return table, serve(t, table)
+}
+
+// serve starts a server for table and connects a client to it.
+func serve(t *testing.T, table *cacher.CacheTable) *client {
+	s := NewServer(table)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{t: t, c: c, r: bufio.NewReader(c)}
+}
+
+// do sends req and reads as many lines as expected.
+func (c *client) do(req string, expected ...string) {
+	c.t.Helper()
+	if _, err := c.c.Write([]byte(req)); err != nil {
+		c.t.Fatal(err)
+	}
+	for _, want := range expected {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(line, "\r\n"); got != want {
+			c.t.Errorf("%q: got %q, want %q", strings.TrimSpace(req), got, want)
+		}
+	}
+}
+
+func TestStorageCommands(t *testing.T) {
+	table, c := startServer(t, "testMemcacheStorage")
+
+	c.do("get foo\r\n", "END")
+	c.do("set foo 5 0 3\r\nbar\r\n", "STORED")
+	c.do("get foo\r\n", "VALUE foo 5 3", "bar", "END")
+	c.do("add foo 0 0 1\r\nx\r\n", "NOT_STORED")
+	c.do("replace nope 0 0 1\r\nx\r\n", "NOT_STORED")
+	c.do("append foo 0 0 2\r\n!!\r\n", "STORED")
+	c.do("prepend foo 0 0 2\r\n<<\r\n", "STORED")
+	c.do("get foo\r\n", "VALUE foo 5 7", "<<bar!!", "END")
+	c.do("set quiet 0 0 1 noreply\r\nq\r\n")
+	c.do("delete foo\r\n", "DELETED")
+	c.do("delete foo\r\n", "NOT_FOUND")
+	c.do("get quiet foo\r\n", "VALUE quiet 0 1", "q", "END")
+
+	// items stored by Go code are served as well
+	table.Set("native", 0, "hello")
+	c.do("get native\r\n", "VALUE native 0 5", "hello", "END")
+}
+
+func TestCASAndCounters(t *testing.T) {
+	_, c := startServer(t, "testMemcacheCAS")
+
+	c.do("set n 0 0 2\r\n10\r\n", "STORED")
+	c.do("incr n 5\r\n", "15")
+	c.do("decr n 20\r\n", "0")
+	c.do("incr missing 1\r\n", "NOT_FOUND")
+	c.do("set s 0 0 1\r\nx\r\n", "STORED")
+	c.do("incr s 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+
+	c.do("gets s\r\n")
+	line, _ := c.r.ReadString('\n')
+	fields := strings.Fields(line)
+	if len(fields) != 5 {
+		t.Fatalf("unexpected gets reply %q", line)
+	}
+	c.do("", "x", "END")
+	c.do("cas s 0 0 1 "+fields[4]+"\r\ny\r\n", "STORED")
+	c.do("cas s 0 0 1 "+fields[4]+"\r\nz\r\n", "EXISTS")
+	c.do("cas gone 0 0 1 1\r\nz\r\n", "NOT_FOUND")
+	c.do("get s\r\n", "VALUE s 0 1", "y", "END")
+}
+
+func TestExpiration(t *testing.T) {
+	table, c := startServer(t, "testMemcacheExpiration")
+
+	c.do("set a 0 100 1\r\na\r\n", "STORED")
+	item, err := table.Get("a")
+	if err != nil || item.LifeSpan() != 100*time.Second {
+		t.Error("exptime wasn't mapped to the item's lifeSpan")
+	}
+	c.do("set b 0 -1 1\r\nb\r\n", "STORED")
+	c.do("get b\r\n", "END")
+	c.do("touch a 0\r\n", "TOUCHED")
+	item, _ = table.Get("a")
+	if !item.ExpiresAt().IsZero() {
+		t.Error("touch didn't make the item persistent")
+	}
+	c.do("touch b 10\r\n", "NOT_FOUND")
+	hits := table.Stats().Snapshot().Hits
+	c.do("set a 0 0 1\r\nc\r\n", "STORED")
+	c.do("touch a 10\r\n", "TOUCHED")
+	c.do("delete a\r\n", "DELETED")
+	if n := table.Stats().Snapshot().Hits - hits; n != 0 {
+		t.Errorf("Storage commands counted %d hits", n)
+	}
+	c.do("flush_all\r\n", "OK")
+	c.do("get a\r\n", "END")
+}
+
+func TestDefaultTTL(t *testing.T) {
+	r := cacher.NewRegistry()
+	defer r.CloseAll()
+	table, err := r.NewWithOptions("testMemcacheDefaultTTL", cacher.WithDefaultTTL(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := serve(t, table)
+
+	c.do("set a 0 0 1\r\na\r\n", "STORED")
+	c.do("append a 0 0 1\r\nb\r\n", "STORED")
+	c.do("set n 0 0 1\r\n1\r\n", "STORED")
+	c.do("incr n 1\r\n", "2")
+	for _, key := range []string{"a", "n"} {
+		if item, err := table.Peek(key); err != nil || !item.ExpiresAt().IsZero() {
+			t.Errorf("Item %s with exptime 0 got the table's default TTL", key)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, c := startServer(t, "testMemcacheStats")
+
+	c.do("set a 0 0 1\r\na\r\n", "STORED")
+	c.do("get a b\r\n", "VALUE a 0 1", "a", "END")
+	c.c.Write([]byte("stats\r\n"))
+	stats := map[string]string{}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "END\r\n" {
+			break
+		}
+		f := strings.Fields(line)
+		stats[f[1]] = f[2]
+	}
+	for name, want := range map[string]string{
+		"curr_items": "1",
+		"cmd_get":    "2",
+		"get_hits":   "1",
+		"get_misses": "1",
+		"cmd_set":    "1",
+	} {
+		if stats[name] != want {
+			t.Errorf("stat %s = %q, want %q", name, stats[name], want)
+		}
+	}
+	c.do("stats reset\r\n", "RESET")
+	c.do("version\r\n", "VERSION "+Version)
+	c.do("bogus\r\n", "ERROR")
+}
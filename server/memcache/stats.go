@@ -0,0 +1,114 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package memcache
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+// counters are the protocol level statistics of a server. They are only
+// accessed atomically.
+type counters struct {
+	currConnections  uint64
+	totalConnections uint64
+
+	cmdGet   uint64
+	cmdSet   uint64
+	cmdFlush uint64
+	cmdTouch uint64
+
+	getHits     uint64
+	getMisses   uint64
+	deleteHits  uint64
+	deleteMiss  uint64
+	incrHits    uint64
+	incrMisses  uint64
+	decrHits    uint64
+	decrMisses  uint64
+	casHits     uint64
+	casMisses   uint64
+	casBadval   uint64
+	touchHits   uint64
+	touchMisses uint64
+}
+
+// reset zeroes the command counters. Connection counters are kept as
+// memcached does.
+func (c *counters) reset() {
+	for _, p := range []*uint64{
+		&c.totalConnections,
+		&c.cmdGet, &c.cmdSet, &c.cmdFlush, &c.cmdTouch,
+		&c.getHits, &c.getMisses, &c.deleteHits, &c.deleteMiss,
+		&c.incrHits, &c.incrMisses, &c.decrHits, &c.decrMisses,
+		&c.casHits, &c.casMisses, &c.casBadval, &c.touchHits, &c.touchMisses,
+	} {
+		atomic.StoreUint64(p, 0)
+	}
+}
+
+// hit increments hits or misses depending on ok.
+func hit(ok bool, hits, misses *uint64) {
+	if ok {
+		atomic.AddUint64(hits, 1)
+	} else {
+		atomic.AddUint64(misses, 1)
+	}
+}
+
+// writeStats renders the general-purpose statistics block.
+func (s *Server) writeStats(b *strings.Builder) {
+	now := time.Now()
+	stat := func(name string, value string) {
+		b.WriteString("STAT ")
+		b.WriteString(name)
+		b.WriteByte(' ')
+		b.WriteString(value)
+		b.WriteString("\r\n")
+	}
+	counter := func(name string, p *uint64) {
+		stat(name, strconv.FormatUint(atomic.LoadUint64(p), 10))
+	}
+
+	c := s.stats
+	stat("pid", strconv.Itoa(os.Getpid()))
+	stat("uptime", strconv.FormatInt(int64(now.Sub(s.started)/time.Second), 10))
+	stat("time", strconv.FormatInt(now.Unix(), 10))
+	stat("version", Version)
+	stat("pointer_size", strconv.Itoa(32<<(^uintptr(0)>>63)))
+	counter("curr_connections", &c.currConnections)
+	counter("total_connections", &c.totalConnections)
+	counter("cmd_get", &c.cmdGet)
+	counter("cmd_set", &c.cmdSet)
+	counter("cmd_flush", &c.cmdFlush)
+	counter("cmd_touch", &c.cmdTouch)
+	counter("get_hits", &c.getHits)
+	counter("get_misses", &c.getMisses)
+	counter("delete_misses", &c.deleteMiss)
+	counter("delete_hits", &c.deleteHits)
+	counter("incr_misses", &c.incrMisses)
+	counter("incr_hits", &c.incrHits)
+	counter("decr_misses", &c.decrMisses)
+	counter("decr_hits", &c.decrHits)
+	counter("cas_misses", &c.casMisses)
+	counter("cas_hits", &c.casHits)
+	counter("cas_badval", &c.casBadval)
+	counter("touch_hits", &c.touchHits)
+	counter("touch_misses", &c.touchMisses)
+	// Item counters are kept by the table itself.
+	table := s.table.Stats().Snapshot()
+	stat("curr_items", strconv.Itoa(s.table.Count()))
+	stat("total_items", strconv.FormatInt(table.Sets, 10))
+	stat("evictions", strconv.FormatInt(table.Evictions[cacher.ReasonCapacity], 10))
+	stat("reclaimed", strconv.FormatInt(table.Evictions[cacher.ReasonExpired], 10))
+	b.WriteString("END\r\n")
+}
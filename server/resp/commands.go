@@ -0,0 +1,823 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+// errQuit ends a connection on the QUIT command.
+var errQuit = errors.New("quit")
+
+// conn is a single client connection.
+type conn struct {
+	server *Server
+	id     uint64
+	name   string
+	db     int
+	r      *bufio.Reader
+	w      *writer
+}
+
+// command describes the arity and handler of a command. A negative arity
+// means at least -arity arguments, including the command name.
+type command struct {
+	arity int
+	fn    func(c *conn, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {-1, (*conn).ping},
+		"ECHO":    {2, (*conn).echo},
+		"QUIT":    {-1, (*conn).quit},
+		"HELLO":   {-1, (*conn).hello},
+		"SELECT":  {2, (*conn).selectDB},
+		"CLIENT":  {-2, (*conn).client},
+		"COMMAND": {-1, (*conn).command},
+		"GET":     {2, (*conn).get},
+		"SET":     {-3, (*conn).set},
+		"DEL":     {-2, (*conn).del},
+		"EXISTS":  {-2, (*conn).exists},
+		"EXPIRE":  {3, (*conn).expire},
+		"TTL":     {2, (*conn).ttl},
+		"PTTL":    {2, (*conn).ttl},
+		"PERSIST": {2, (*conn).persist},
+		"INCR":    {2, (*conn).incr},
+		"DECR":    {2, (*conn).incr},
+		"INCRBY":  {3, (*conn).incr},
+		"DECRBY":  {3, (*conn).incr},
+		"MGET":    {-2, (*conn).mget},
+		"MSET":    {-3, (*conn).mset},
+		"SCAN":    {-2, (*conn).scan},
+		"DBSIZE":  {1, (*conn).dbsize},
+		"FLUSHDB": {-1, (*conn).flushdb},
+		"INFO":    {-1, (*conn).info},
+	}
+}
+
+// exec runs a single command.
+func (c *conn) exec(args []string) error {
+	name := strings.ToUpper(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.err(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return nil
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		c.w.err(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return nil
+	}
+	args[0] = name
+	return cmd.fn(c, args)
+}
+
+// render converts cached data into a Redis string.
+func render(data interface{}) string {
+	switch d := data.(type) {
+	case string:
+		return d
+	case []byte:
+		return string(d)
+	case nil:
+		return ""
+	case fmt.Stringer:
+		return d.String()
+	default:
+		return fmt.Sprint(d)
+	}
+}
+
+// remaining returns the time item has left to live, cacher.NoExpiration if
+// it never expires.
+func remaining(item *cacher.CacheItem) (time.Duration, bool) {
+	expiresAt := item.ExpiresAt()
+	if expiresAt.IsZero() {
+		return cacher.NoExpiration, true
+	}
+	d := time.Until(expiresAt)
+	return d, d > 0
+}
+
+// expireDuration returns n units as a duration, or -1 if that overflows.
+func expireDuration(n int64, unit time.Duration) time.Duration {
+	if n > math.MaxInt64/int64(unit) {
+		return -1
+	}
+	return time.Duration(n) * unit
+}
+
+// lookup returns the live item stored under key without invoking the
+// table's data loader or counting an access.
+func lookup(table *cacher.CacheTable, key string) (*cacher.CacheItem, bool) {
+	item, err := table.Peek(key)
+	if err != nil {
+		return nil, false
+	}
+	if _, ok := remaining(item); !ok {
+		return nil, false
+	}
+	return item, true
+}
+
+func (c *conn) ping(args []string) error {
+	switch len(args) {
+	case 1:
+		c.w.simple("PONG")
+	case 2:
+		c.w.bulk(args[1])
+	default:
+		c.w.err("ERR wrong number of arguments for 'ping' command")
+	}
+	return nil
+}
+
+func (c *conn) echo(args []string) error {
+	c.w.bulk(args[1])
+	return nil
+}
+
+func (c *conn) quit(args []string) error {
+	c.w.simple("OK")
+	return errQuit
+}
+
+func (c *conn) hello(args []string) error {
+	proto := c.w.proto
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 2 || v > 3 {
+			c.w.err("NOPROTO unsupported protocol version")
+			return nil
+		}
+		proto = v
+	}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			// There are no users to authenticate.
+			i += 2
+		case "SETNAME":
+			if i+1 < len(args) {
+				c.name = args[i+1]
+			}
+			i++
+		}
+	}
+	c.w.proto = proto
+
+	c.w.mapHeader(7)
+	c.w.bulk("server")
+	c.w.bulk("redis")
+	c.w.bulk("version")
+	c.w.bulk(Version)
+	c.w.bulk("proto")
+	c.w.int(int64(proto))
+	c.w.bulk("id")
+	c.w.int(int64(c.id))
+	c.w.bulk("mode")
+	c.w.bulk("standalone")
+	c.w.bulk("role")
+	c.w.bulk("master")
+	c.w.bulk("modules")
+	c.w.array(0)
+	return nil
+}
+
+func (c *conn) selectDB(args []string) error {
+	db, err := strconv.Atoi(args[1])
+	if err != nil {
+		c.w.err("ERR value is not an integer or out of range")
+		return nil
+	}
+	max := 1
+	if c.server.Mode == ModeDB {
+		max = len(c.server.dbs())
+	}
+	if db < 0 || db >= max {
+		c.w.err("ERR DB index is out of range")
+		return nil
+	}
+	c.db = db
+	c.w.simple("OK")
+	return nil
+}
+
+func (c *conn) client(args []string) error {
+	switch strings.ToUpper(args[1]) {
+	case "SETNAME":
+		if len(args) == 3 {
+			c.name = args[2]
+		}
+		c.w.simple("OK")
+	case "GETNAME":
+		if c.name == "" {
+			c.w.null()
+		} else {
+			c.w.bulk(c.name)
+		}
+	case "ID":
+		c.w.int(int64(c.id))
+	default:
+		c.w.simple("OK")
+	}
+	return nil
+}
+
+func (c *conn) command(args []string) error {
+	// Clients only use this for introspection; pretend there is nothing to
+	// describe.
+	c.w.array(0)
+	return nil
+}
+
+func (c *conn) get(args []string) error {
+	table, key, err := c.server.resolve(c.db, args[1])
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	item, err := table.Get(key)
+	if err != nil {
+		c.w.null()
+		return nil
+	}
+	if _, ok := remaining(item); !ok {
+		c.w.null()
+		return nil
+	}
+	c.w.bulk(render(item.Data()))
+	return nil
+}
+
+func (c *conn) set(args []string) error {
+	var (
+		life            = cacher.NoExpiration
+		nx, xx, keepTTL bool
+		get             bool
+		expires         bool
+	)
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expires || i+1 >= len(args) {
+				c.w.err("ERR syntax error")
+				return nil
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				c.w.err("ERR value is not an integer or out of range")
+				return nil
+			}
+			switch opt {
+			case "EX":
+				life = expireDuration(n, time.Second)
+			case "PX":
+				life = expireDuration(n, time.Millisecond)
+			case "EXAT":
+				life = time.Until(time.Unix(n, 0))
+			case "PXAT":
+				life = time.Until(time.Unix(0, n*int64(time.Millisecond)))
+			}
+			if n <= 0 || (opt[len(opt)-2:] != "AT" && life <= 0) {
+				c.w.err("ERR invalid expire time in 'set' command")
+				return nil
+			}
+			expires = true
+		default:
+			c.w.err("ERR syntax error")
+			return nil
+		}
+	}
+	if (nx && xx) || (keepTTL && expires) {
+		c.w.err("ERR syntax error")
+		return nil
+	}
+
+	table, key, err := c.server.resolve(c.db, args[1])
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	unlock := c.server.lock(table, key)
+	defer unlock()
+
+	old, exists := lookup(table, key)
+	reply := func() {
+		switch {
+		case get && exists:
+			c.w.bulk(render(old.Data()))
+		case get:
+			c.w.null()
+		default:
+			c.w.simple("OK")
+		}
+	}
+	if (nx && exists) || (xx && !exists) {
+		if get {
+			reply()
+		} else {
+			c.w.null()
+		}
+		return nil
+	}
+
+	if keepTTL && exists {
+		// An item which expired meanwhile is gone, so it has no TTL to keep.
+		if l, live := remaining(old); live {
+			life = l
+		}
+	}
+	if expires && life <= 0 {
+		// An absolute expiration in the past deletes the key.
+		table.Delete(key)
+	} else {
+		table.Set(key, life, args[2])
+	}
+	reply()
+	return nil
+}
+
+func (c *conn) del(args []string) error {
+	var n int64
+	for _, k := range args[1:] {
+		table, key, err := c.server.resolve(c.db, k)
+		if err != nil {
+			c.w.err(err.Error())
+			return nil
+		}
+		unlock := c.server.lock(table, key)
+		if _, ok := lookup(table, key); ok {
+			table.Delete(key)
+			n++
+		}
+		unlock()
+	}
+	c.w.int(n)
+	return nil
+}
+
+func (c *conn) exists(args []string) error {
+	var n int64
+	for _, k := range args[1:] {
+		table, key, err := c.server.resolve(c.db, k)
+		if err != nil {
+			c.w.err(err.Error())
+			return nil
+		}
+		if _, ok := lookup(table, key); ok {
+			n++
+		}
+	}
+	c.w.int(n)
+	return nil
+}
+
+func (c *conn) expire(args []string) error {
+	seconds, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.w.err("ERR value is not an integer or out of range")
+		return nil
+	}
+	life := expireDuration(seconds, time.Second)
+	if seconds > 0 && life <= 0 {
+		c.w.err("ERR invalid expire time in 'expire' command")
+		return nil
+	}
+	table, key, err := c.server.resolve(c.db, args[1])
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	unlock := c.server.lock(table, key)
+	defer unlock()
+
+	_, ok := lookup(table, key)
+	if !ok {
+		c.w.int(0)
+		return nil
+	}
+	if seconds <= 0 {
+		table.Delete(key)
+	} else {
+		table.Touch(key, life)
+	}
+	c.w.int(1)
+	return nil
+}
+
+func (c *conn) ttl(args []string) error {
+	table, key, err := c.server.resolve(c.db, args[1])
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	item, ok := lookup(table, key)
+	if !ok {
+		c.w.int(-2)
+		return nil
+	}
+	if item.ExpiresAt().IsZero() {
+		c.w.int(-1)
+		return nil
+	}
+	d, _ := remaining(item)
+	if args[0] == "PTTL" {
+		c.w.int(int64((d + time.Millisecond/2) / time.Millisecond))
+	} else {
+		c.w.int(int64((d + time.Second/2) / time.Second))
+	}
+	return nil
+}
+
+func (c *conn) persist(args []string) error {
+	table, key, err := c.server.resolve(c.db, args[1])
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	unlock := c.server.lock(table, key)
+	defer unlock()
+
+	item, ok := lookup(table, key)
+	if !ok || item.ExpiresAt().IsZero() {
+		c.w.int(0)
+		return nil
+	}
+	table.Persist(key)
+	c.w.int(1)
+	return nil
+}
+
+func (c *conn) incr(args []string) error {
+	delta := int64(1)
+	if len(args) == 3 {
+		var err error
+		if delta, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			c.w.err("ERR value is not an integer or out of range")
+			return nil
+		}
+	}
+	if args[0] == "DECR" || args[0] == "DECRBY" {
+		if delta == math.MinInt64 {
+			c.w.err("ERR decrement would overflow")
+			return nil
+		}
+		delta = -delta
+	}
+
+	table, key, err := c.server.resolve(c.db, args[1])
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	unlock := c.server.lock(table, key)
+	defer unlock()
+
+	var value int64
+	life := cacher.NoExpiration
+	if item, ok := lookup(table, key); ok {
+		// An item which expired meanwhile counts as missing.
+		if l, live := remaining(item); live {
+			if value, err = strconv.ParseInt(render(item.Data()), 10, 64); err != nil {
+				c.w.err("ERR value is not an integer or out of range")
+				return nil
+			}
+			life = l
+		}
+	}
+	if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+		c.w.err("ERR increment or decrement would overflow")
+		return nil
+	}
+	value += delta
+
+	table.Set(key, life, strconv.FormatInt(value, 10))
+	c.w.int(value)
+	return nil
+}
+
+func (c *conn) mget(args []string) error {
+	c.w.array(len(args) - 1)
+	for _, k := range args[1:] {
+		table, key, err := c.server.resolve(c.db, k)
+		if err != nil {
+			c.w.null()
+			continue
+		}
+		item, err := table.Get(key)
+		if err != nil {
+			c.w.null()
+			continue
+		}
+		if _, ok := remaining(item); !ok {
+			c.w.null()
+			continue
+		}
+		c.w.bulk(render(item.Data()))
+	}
+	return nil
+}
+
+func (c *conn) mset(args []string) error {
+	if len(args)%2 != 1 {
+		c.w.err("ERR wrong number of arguments for 'mset' command")
+		return nil
+	}
+
+	// Resolve everything first so a bad key doesn't leave a partial write.
+	tables := make([]*cacher.CacheTable, 0, len(args)/2)
+	keys := make([]string, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		table, key, err := c.server.resolve(c.db, args[i])
+		if err != nil {
+			c.w.err(err.Error())
+			return nil
+		}
+		tables = append(tables, table)
+		keys = append(keys, key)
+	}
+	for i, table := range tables {
+		unlock := c.server.lock(table, keys[i])
+		table.Set(keys[i], cacher.NoExpiration, args[2*i+2])
+		unlock()
+	}
+	c.w.simple("OK")
+	return nil
+}
+
+// keys returns the external names of all string keys visible to the
+// client, sorted so that cursors stay meaningful between SCAN calls.
+func (c *conn) keys() ([]string, error) {
+	tables, err := c.server.tables(c.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, table := range tables {
+		table.Foreach(func(key interface{}, item *cacher.CacheItem) {
+			if k, ok := key.(string); ok {
+				if _, live := remaining(item); live {
+					keys = append(keys, c.server.external(table, k))
+				}
+			}
+		})
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (c *conn) scan(args []string) error {
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		c.w.err("ERR invalid cursor")
+		return nil
+	}
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.w.err("ERR syntax error")
+			return nil
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				c.w.err("ERR syntax error")
+				return nil
+			}
+		case "TYPE":
+			// Every key holds a string.
+			if !strings.EqualFold(args[i+1], "string") {
+				count = 0
+			}
+		default:
+			c.w.err("ERR syntax error")
+			return nil
+		}
+	}
+
+	keys, err := c.keys()
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	var found []string
+	next := cursor
+	if count > 0 {
+		for ; next < len(keys) && next < cursor+count; next++ {
+			if match(pattern, keys[next]) {
+				found = append(found, keys[next])
+			}
+		}
+	} else {
+		next = len(keys)
+	}
+	if next >= len(keys) {
+		next = 0
+	}
+
+	c.w.array(2)
+	c.w.bulk(strconv.Itoa(next))
+	c.w.array(len(found))
+	for _, k := range found {
+		c.w.bulk(k)
+	}
+	return nil
+}
+
+func (c *conn) dbsize(args []string) error {
+	tables, err := c.server.tables(c.db)
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	var n int
+	for _, table := range tables {
+		n += table.Count()
+	}
+	c.w.int(int64(n))
+	return nil
+}
+
+func (c *conn) flushdb(args []string) error {
+	if len(args) > 2 || (len(args) == 2 && !strings.EqualFold(args[1], "ASYNC") && !strings.EqualFold(args[1], "SYNC")) {
+		c.w.err("ERR syntax error")
+		return nil
+	}
+	tables, err := c.server.tables(c.db)
+	if err != nil {
+		c.w.err(err.Error())
+		return nil
+	}
+	for _, table := range tables {
+		table.Flush()
+	}
+	c.w.simple("OK")
+	return nil
+}
+
+func (c *conn) info(args []string) error {
+	section := "all"
+	if len(args) > 1 {
+		section = strings.ToLower(args[1])
+	}
+	want := func(name string) bool {
+		return section == "all" || section == "default" || section == "everything" || section == name
+	}
+
+	s := c.server
+	var b strings.Builder
+	if want("server") {
+		mode := "db"
+		if s.Mode == ModePrefix {
+			mode = "prefix"
+		}
+		fmt.Fprintf(&b, "# Server\r\nredis_version:%s\r\nredis_mode:standalone\r\nprocess_id:%d\r\nuptime_in_seconds:%d\r\ncacher_mode:%s\r\n\r\n",
+			Version, os.Getpid(), int64(time.Since(s.started)/time.Second), mode)
+	}
+	if want("clients") {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		fmt.Fprintf(&b, "# Clients\r\nconnected_clients:%d\r\n\r\n", n)
+	}
+	if want("keyspace") {
+		b.WriteString("# Keyspace\r\n")
+		if s.Mode == ModeDB {
+			for db, name := range s.dbs() {
+				if table, ok := s.registry().Lookup(name); ok {
+					keys, expires := keyspace(table)
+					fmt.Fprintf(&b, "db%d:keys=%d,expires=%d,avg_ttl=0,table=%s\r\n", db, keys, expires, name)
+				}
+			}
+		} else {
+			var keys, expires int
+			for _, name := range s.registry().Tables() {
+				if table, ok := s.registry().Lookup(name); ok {
+					k, e := keyspace(table)
+					keys += k
+					expires += e
+				}
+			}
+			fmt.Fprintf(&b, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", keys, expires)
+		}
+		b.WriteString("\r\n")
+	}
+	c.w.verbatim(b.String())
+	return nil
+}
+
+// keyspace counts the items of table and how many of them expire.
+func keyspace(table *cacher.CacheTable) (keys, expires int) {
+	table.Foreach(func(key interface{}, item *cacher.CacheItem) {
+		keys++
+		if !item.ExpiresAt().IsZero() {
+			expires++
+		}
+	})
+	return keys, expires
+}
+
+// match reports whether s matches the glob-style pattern as understood by
+// Redis: *, ?, [...] with ranges and negation, and \ escapes.
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// An unterminated class matches literally.
+				if s[0] != '[' {
+					return false
+				}
+				break
+			}
+			class := pattern[1 : end+1]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if class[i] == '\\' && i+1 < len(class) {
+					i++
+					matched = matched || class[i] == s[0]
+				} else if i+2 < len(class) && class[i+1] == '-' {
+					lo, hi := class[i], class[i+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					matched = matched || (s[0] >= lo && s[0] <= hi)
+					i += 2
+				} else {
+					matched = matched || class[i] == s[0]
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,278 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+// Package resp exposes the tables of the cacher registry to redis-cli and
+// Redis client libraries. It speaks RESP2 and RESP3 and implements a subset
+// of the Redis string commands.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+// Version is reported as redis_version by INFO and HELLO.
+const Version = "7.0.0-cacher"
+
+// ErrServerClosed is returned by Serve after Close was called.
+var ErrServerClosed = errors.New("resp: Server closed")
+
+// Mode selects how tables are addressed by clients.
+type Mode int
+
+const (
+	// ModeDB maps every table to a logical database chosen with SELECT.
+	ModeDB Mode = iota
+	// ModePrefix addresses tables by a key prefix, e.g. "users:42" is
+	// key "42" of table "users".
+	ModePrefix
+)
+
+// lockStripes is the number of mutexes serializing read-modify-write
+// commands on the same key.
+const lockStripes = 64
+
+// Server serves the tables of a cacher registry.
+type Server struct {
+	// Mode selects how tables are addressed.
+	Mode Mode
+	// DBs lists the table of each logical database in ModeDB. If empty,
+	// database n is the n-th table of the registry.
+	DBs []string
+	// Registry holds the served tables. cacher.DefaultRegistry() is used if
+	// nil.
+	Registry *cacher.Registry
+	// Separator splits table and key in ModePrefix. Defaults to ":".
+	Separator string
+
+	clients uint64
+	locks   [lockStripes]sync.Mutex
+	started time.Time
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// NewServer returns a server in the given mode.
+func NewServer(mode Mode) *Server {
+	return &Server{
+		Mode:      mode,
+		Separator: ":",
+		started:   time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it fails or the server gets closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go s.serveConn(c)
+	}
+}
+
+// Close stops all listeners and closes all open connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+
+	var err error
+	for l := range s.listeners {
+		if err1 := l.Close(); err == nil {
+			err = err1
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	conn := &conn{
+		server: s,
+		id:     atomic.AddUint64(&s.clients, 1),
+		r:      bufio.NewReader(c),
+		w:      &writer{Writer: bufio.NewWriter(c), proto: 2},
+	}
+	for {
+		args, err := readCommand(conn.r)
+		if err == errProtocol {
+			conn.w.err("ERR Protocol error")
+		} else if err == nil && len(args) > 0 {
+			err = conn.exec(args)
+		}
+		// Pipelined commands get answered in one write.
+		if conn.r.Buffered() == 0 || err != nil {
+			if err1 := conn.w.Flush(); err == nil {
+				err = err1
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// lock serializes commands on key of table.
+func (s *Server) lock(table *cacher.CacheTable, key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(table.Name()))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	m := &s.locks[h.Sum32()%lockStripes]
+	m.Lock()
+	return m.Unlock
+}
+
+// registry returns the registry of the served tables.
+func (s *Server) registry() *cacher.Registry {
+	if s.Registry == nil {
+		return cacher.DefaultRegistry()
+	}
+	return s.Registry
+}
+
+// dbs returns the table names of the logical databases.
+func (s *Server) dbs() []string {
+	if len(s.DBs) > 0 {
+		return s.DBs
+	}
+	return s.registry().Tables()
+}
+
+func (s *Server) separator() string {
+	if s.Separator == "" {
+		return ":"
+	}
+	return s.Separator
+}
+
+// tables returns the tables visible to a client using database db.
+func (s *Server) tables(db int) ([]*cacher.CacheTable, error) {
+	if s.Mode == ModeDB {
+		t, err := s.table(db)
+		if err != nil {
+			return nil, err
+		}
+		return []*cacher.CacheTable{t}, nil
+	}
+
+	var tables []*cacher.CacheTable
+	for _, name := range s.registry().Tables() {
+		if t, ok := s.registry().Lookup(name); ok {
+			tables = append(tables, t)
+		}
+	}
+	return tables, nil
+}
+
+// table returns the table of database db in ModeDB.
+func (s *Server) table(db int) (*cacher.CacheTable, error) {
+	dbs := s.dbs()
+	if db >= len(dbs) {
+		return nil, errors.New("ERR DB index is out of range")
+	}
+	t, ok := s.registry().Lookup(dbs[db])
+	if !ok {
+		return nil, fmt.Errorf("ERR no such table '%s'", dbs[db])
+	}
+	return t, nil
+}
+
+// resolve maps a client key to a table and a cache key.
+func (s *Server) resolve(db int, key string) (*cacher.CacheTable, string, error) {
+	if s.Mode == ModeDB {
+		t, err := s.table(db)
+		return t, key, err
+	}
+
+	i := strings.Index(key, s.separator())
+	if i < 0 {
+		return nil, "", fmt.Errorf("ERR key '%s' has no table prefix", key)
+	}
+	t, ok := s.registry().Lookup(key[:i])
+	if !ok {
+		return nil, "", fmt.Errorf("ERR no such table '%s'", key[:i])
+	}
+	return t, key[i+len(s.separator()):], nil
+}
+
+// external returns the name clients know key of table by.
+func (s *Server) external(table *cacher.CacheTable, key string) string {
+	if s.Mode == ModeDB {
+		return key
+	}
+	return table.Name() + s.separator() + key
+}
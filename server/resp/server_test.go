@@ -0,0 +1,232 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package resp
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher"
+)
+
+type client struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+func startServer(t *testing.T, s *Server) *client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{t: t, c: c, r: bufio.NewReader(c)}
+}
+
+// do sends args as a RESP array and compares the raw reply lines.
+func (c *client) do(args []string, expected ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		b.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+	}
+	if _, err := c.c.Write([]byte(b.String())); err != nil {
+		c.t.Fatal(err)
+	}
+	for _, want := range expected {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(line, "\r\n"); got != want {
+			c.t.Errorf("%v: got %q, want %q", args, got, want)
+		}
+	}
+}
+
+func cmd(s string) []string {
+	return strings.Fields(s)
+}
+
+func TestDBMode(t *testing.T) {
+	a := cacher.New("testRespA", time.Second)
+	b := cacher.New("testRespB", time.Second)
+	a.Flush()
+	b.Flush()
+	s := NewServer(ModeDB)
+	s.DBs = []string{"testRespA", "testRespB"}
+	c := startServer(t, s)
+
+	c.do(cmd("PING"), "+PONG")
+	c.do(cmd("GET k"), "$-1")
+	c.do(cmd("SET k v"), "+OK")
+	c.do(cmd("GET k"), "$1", "v")
+	if !a.Exists("k") || b.Exists("k") {
+		t.Error("SET didn't write to the table of db 0")
+	}
+	c.do(cmd("SET k w NX"), "$-1")
+	c.do(cmd("SET n 10 XX"), "$-1")
+	c.do(cmd("SELECT 1"), "+OK")
+	c.do(cmd("EXISTS k"), ":0")
+	c.do(cmd("MSET x 1 y 2"), "+OK")
+	c.do(cmd("MGET x nope y"), "*3", "$1", "1", "$-1", "$1", "2")
+	c.do(cmd("INCRBY x 41"), ":42")
+	c.do(cmd("INCR fresh"), ":1")
+	c.do(cmd("SET s abc"), "+OK")
+	c.do(cmd("INCRBY s 1"), "-ERR value is not an integer or out of range")
+	c.do(cmd("DBSIZE"), ":4")
+	c.do(cmd("DEL x y nope"), ":2")
+	c.do(cmd("FLUSHDB"), "+OK")
+	c.do(cmd("DBSIZE"), ":0")
+	c.do(cmd("SELECT 2"), "-ERR DB index is out of range")
+	c.do(cmd("NOSUCH"), "-ERR unknown command 'NOSUCH'")
+}
+
+func TestExpiration(t *testing.T) {
+	table := cacher.New("testRespExpire", time.Second)
+	table.Flush()
+	s := NewServer(ModeDB)
+	s.DBs = []string{"testRespExpire"}
+	c := startServer(t, s)
+
+	c.do(cmd("SET k v EX 100"), "+OK")
+	item, _ := table.Get("k")
+	if item.LifeSpan() != 100*time.Second {
+		t.Error("EX wasn't mapped to the item's lifeSpan")
+	}
+	c.do(cmd("TTL k"), ":100")
+	c.do(cmd("PERSIST k"), ":1")
+	c.do(cmd("TTL k"), ":-1")
+	c.do(cmd("EXPIRE k 5"), ":1")
+	c.do(cmd("TTL k"), ":5")
+	c.do(cmd("SET k w KEEPTTL"), "+OK")
+	c.do(cmd("TTL k"), ":5")
+	c.do(cmd("TTL nope"), ":-2")
+	c.do(cmd("EXPIRE k 0"), ":1")
+	c.do(cmd("EXISTS k"), ":0")
+	c.do(cmd("SET k v PX 0"), "-ERR invalid expire time in 'set' command")
+	c.do(cmd("SET k v EX 9223372036854775807"), "-ERR invalid expire time in 'set' command")
+	c.do(cmd("SET k v"), "+OK")
+	c.do(cmd("EXPIRE k 9223372036854775807"), "-ERR invalid expire time in 'expire' command")
+	c.do(cmd("TTL k"), ":-1")
+
+	table.Set("persistent", cacher.NoExpiration, "v")
+	c.do(cmd("TTL persistent"), ":-1")
+	c.do(cmd("PERSIST persistent"), ":0")
+
+	hits := table.Stats().Snapshot().Hits
+	c.do(cmd("EXISTS persistent"), ":1")
+	c.do(cmd("EXPIRE persistent 100"), ":1")
+	c.do(cmd("SET persistent w KEEPTTL"), "+OK")
+	if n := table.Stats().Snapshot().Hits - hits; n != 0 {
+		t.Errorf("Key inspection counted %d hits", n)
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	r := cacher.NewRegistry()
+	defer r.CloseAll()
+	table, err := r.NewWithOptions("testRespDefaultTTL", cacher.WithDefaultTTL(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(ModeDB)
+	s.Registry = r
+	s.DBs = []string{"testRespDefaultTTL"}
+	c := startServer(t, s)
+
+	c.do(cmd("SET a v"), "+OK")
+	c.do(cmd("INCR n"), ":1")
+	c.do(cmd("MSET b v c v"), "+OK")
+	for _, key := range []string{"a", "n", "b", "c"} {
+		c.do(cmd("TTL "+key), ":-1")
+	}
+	c.do(cmd("SET d v EX 100"), "+OK")
+	if keys, expires := keyspace(table); keys != 5 || expires != 1 {
+		t.Errorf("Unexpected keyspace: %d keys, %d expiring", keys, expires)
+	}
+}
+
+func TestPrefixModeAndScan(t *testing.T) {
+	users := cacher.New("testRespUsers", time.Second)
+	users.Flush()
+	c := startServer(t, NewServer(ModePrefix))
+
+	c.do(cmd("SET testRespUsers:1 alice"), "+OK")
+	c.do(cmd("SET testRespUsers:2 bob"), "+OK")
+	c.do(cmd("SET nosuchtable:1 x"), "-ERR no such table 'nosuchtable'")
+	if item, err := users.Get("1"); err != nil || item.Data() != "alice" {
+		t.Error("SET didn't write to the prefixed table")
+	}
+	c.do(cmd("SCAN 0 MATCH testRespUsers:* COUNT 1000"), "*2", "$1", "0", "*2",
+		"$15", "testRespUsers:1", "$15", "testRespUsers:2")
+	c.do(cmd("SELECT 1"), "-ERR DB index is out of range")
+}
+
+func TestHello(t *testing.T) {
+	r := cacher.NewRegistry()
+	defer r.CloseAll()
+	s := NewServer(ModePrefix)
+	s.Registry = r
+	c := startServer(t, s)
+
+	c.do(cmd("HELLO 3"), "%7")
+	// the map's pairs take 25 lines
+	for i := 0; i < 25; i++ {
+		c.r.ReadString('\n')
+	}
+	c.do(cmd("GET testRespHello:nope"), "-ERR no such table 'testRespHello'")
+	r.New("testRespHello", time.Second)
+	c.do(cmd("GET testRespHello:nope"), "_")
+	c.do(cmd("HELLO 4"), "-NOPROTO unsupported protocol version")
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:42", true},
+		{"user:?", "user:42", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+	} {
+		if got := match(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestReadCommandInvalid(t *testing.T) {
+	for _, req := range []string{
+		"*-1\r\n",
+		"*x\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\r\n+OK\r\n",
+		"*1\r\n$2\r\nabc\r\n",
+	} {
+		if _, err := readCommand(bufio.NewReader(strings.NewReader(req))); err != errProtocol {
+			t.Errorf("%q: expected errProtocol, got %v", req, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"math/rand"
+)
+
+const (
+	skiplistMaxLevel = 32
+	// skiplistP is the chance of a node to reach the next level.
+	skiplistP = 0.25
+)
+
+// skiplist is a sorted set of string keys. It isn't safe for concurrent
+// use.
+type skiplist struct {
+	head  skipnode
+	level int
+	len   int
+}
+
+type skipnode struct {
+	key  string
+	next []*skipnode
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  skipnode{next: make([]*skipnode, skiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+// path returns the last node before key on every level.
+func (s *skiplist) path(key string) [skiplistMaxLevel]*skipnode {
+	var update [skiplistMaxLevel]*skipnode
+	n := &s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		update[i] = n
+	}
+	return update
+}
+
+// insert adds key. It reports false if key is present already.
+func (s *skiplist) insert(key string) bool {
+	update := s.path(key)
+	if n := update[0].next[0]; n != nil && n.key == key {
+		return false
+	}
+
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	for ; s.level < level; s.level++ {
+		update[s.level] = &s.head
+	}
+	n := &skipnode{key: key, next: make([]*skipnode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	s.len++
+	return true
+}
+
+// remove deletes key. It reports false if key isn't present.
+func (s *skiplist) remove(key string) bool {
+	update := s.path(key)
+	n := update[0].next[0]
+	if n == nil || n.key != key {
+		return false
+	}
+	for i := range n.next {
+		update[i].next[i] = n.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.len--
+	return true
+}
+
+// seek returns the first node with a key not less than key, or nil.
+func (s *skiplist) seek(key string) *skipnode {
+	return s.path(key)[0].next[0]
+}
+
+// scan calls f for the keys in [start, end) in order, until f returns false.
+// An empty end means no upper bound.
+func (s *skiplist) scan(start, end string, f func(key string) bool) {
+	for n := s.seek(start); n != nil; n = n.next[0] {
+		if end != "" && n.key >= end {
+			return
+		}
+		if !f(n.key) {
+			return
+		}
+	}
+}
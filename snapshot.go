@@ -0,0 +1,131 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"time"
+)
+
+// Snapshot is a read-only, point-in-time view of the items of a table. It
+// doesn't change when the table does, and reading it never blocks the
+// table. The items themselves are shared with the table, so their access
+// counters, lifespans and numeric data may still change.
+type Snapshot struct {
+	table string
+	taken time.Time
+	items map[interface{}]*CacheItem
+}
+
+// Snapshot returns a view of the current items of the table. It is cheap:
+// the table only copies its items on the first change after a snapshot was
+// taken.
+func (table *CacheTable) Snapshot() *Snapshot {
+	table.Lock()
+	defer table.Unlock()
+	table.itemsShared = true
+	return &Snapshot{
+		table: table.name,
+		taken: table.clock.Now(),
+		items: table.items,
+	}
+}
+
+// Keys returns the keys of all items currently in the table, in no
+// particular order.
+func (table *CacheTable) Keys() []interface{} {
+	table.RLock()
+	defer table.RUnlock()
+	keys := make([]interface{}, 0, len(table.items))
+	for key := range table.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Items returns a copy of all items currently in the table by key.
+func (table *CacheTable) Items() map[interface{}]*CacheItem {
+	table.RLock()
+	defer table.RUnlock()
+	items := make(map[interface{}]*CacheItem, len(table.items))
+	for key, item := range table.items {
+		items[key] = item
+	}
+	return items
+}
+
+// Iterate calls f for all items currently in the table, until f returns
+// false. The items are copied under the read lock first, so f may change
+// the table; items it adds aren't visited, items it deletes still are.
+func (table *CacheTable) Iterate(f func(key interface{}, item *CacheItem) bool) {
+	table.RLock()
+	items := make([]*CacheItem, 0, len(table.items))
+	for _, item := range table.items {
+		items = append(items, item)
+	}
+	table.RUnlock()
+
+	for _, item := range items {
+		if !f(item.key, item) {
+			return
+		}
+	}
+}
+
+// Table returns the name of the table the snapshot was taken of.
+func (s *Snapshot) Table() string {
+	return s.table
+}
+
+// Taken returns when the snapshot was taken.
+func (s *Snapshot) Taken() time.Time {
+	return s.taken
+}
+
+// Len returns the number of items in the snapshot.
+func (s *Snapshot) Len() int {
+	return len(s.items)
+}
+
+// Get returns the item of key in the snapshot, without marking it to be
+// kept alive.
+func (s *Snapshot) Get(key interface{}) (*CacheItem, bool) {
+	item, ok := s.items[key]
+	return item, ok
+}
+
+// Keys returns the keys in the snapshot, in no particular order.
+func (s *Snapshot) Keys() []interface{} {
+	keys := make([]interface{}, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Iterate calls f for all items in the snapshot, in no particular order,
+// until f returns false.
+func (s *Snapshot) Iterate(f func(key interface{}, item *CacheItem) bool) {
+	for key, item := range s.items {
+		if !f(key, item) {
+			return
+		}
+	}
+}
+
+// unshareInternal copies the items of the table if a snapshot refers to
+// them. Do not run it unless the table-mutex is locked.
+func (table *CacheTable) unshareInternal() {
+	if !table.itemsShared {
+		return
+	}
+	items := make(map[interface{}]*CacheItem, len(table.items))
+	for key, item := range table.items {
+		items[key] = item
+	}
+	table.items = items
+	table.itemsShared = false
+}
@@ -0,0 +1,106 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	table := newCacheTable("testSnapshot", time.Minute)
+	table.Set("a", 0, 1)
+	table.Set("b", 0, 2)
+
+	snap := table.Snapshot()
+	table.Set("c", 0, 3)
+	table.Delete("a")
+	table.Set("b", 0, 20)
+
+	if snap.Len() != 2 || snap.Table() != "testSnapshot" {
+		t.Errorf("Snapshot changed along with the table: %v", snap.Keys())
+	}
+	if item, ok := snap.Get("a"); !ok || item.Data() != 1 {
+		t.Error("Deleted item is missing from the snapshot")
+	}
+	if item, _ := snap.Get("b"); item.Data() != 2 {
+		t.Error("Replaced item changed in the snapshot")
+	}
+	if _, ok := snap.Get("c"); ok {
+		t.Error("Added item showed up in the snapshot")
+	}
+	if table.Count() != 2 || len(table.Keys()) != 2 || len(table.Items()) != 2 {
+		t.Error("Table lost changes made after the snapshot")
+	}
+}
+
+func TestIterate(t *testing.T) {
+	table := newCacheTable("testIterate", time.Minute)
+	for i := 0; i < 10; i++ {
+		table.Set(i, 0, v)
+	}
+
+	// deleting and adding while iterating doesn't deadlock
+	visited := 0
+	table.Iterate(func(key interface{}, item *CacheItem) bool {
+		visited++
+		table.Delete(key)
+		table.Set(key.(int)+100, 0, v)
+		return true
+	})
+	if visited != 10 || table.Count() != 10 || table.Exists(0) {
+		t.Errorf("Visited %d items, table holds %d", visited, table.Count())
+	}
+
+	visited = 0
+	table.Iterate(func(key interface{}, item *CacheItem) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Errorf("Iteration didn't stop early, visited %d items", visited)
+	}
+	if table.itemsShared {
+		t.Error("Iterating marked the items shared, so the next write copies them")
+	}
+
+	table.Foreach(func(key interface{}, item *CacheItem) {
+		table.Delete(key)
+	})
+	if table.Count() != 0 {
+		t.Error("Foreach couldn't delete the items")
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	table := newCacheTable("testSnapshotConcurrent", time.Minute)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			table.Set(i%50, 0, i)
+			table.Delete((i + 25) % 50)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			snap := table.Snapshot()
+			n := 0
+			snap.Iterate(func(key interface{}, item *CacheItem) bool {
+				n++
+				return true
+			})
+			if n != snap.Len() {
+				t.Errorf("Snapshot of %d items iterated %d", snap.Len(), n)
+			}
+		}
+	}()
+	wg.Wait()
+}
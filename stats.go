@@ -0,0 +1,309 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionReason tells why an item left the cache.
+type EvictionReason int
+
+const (
+	// ReasonDeleted means the item was deleted explicitly.
+	ReasonDeleted EvictionReason = iota
+	// ReasonExpired means the item's lifeSpan elapsed.
+	ReasonExpired
+	// ReasonCapacity means the item was evicted to make room for others.
+	ReasonCapacity
+	// ReasonReplaced means the item was overwritten by a new one.
+	ReasonReplaced
+	// ReasonFlushed means the whole table was flushed.
+	ReasonFlushed
+	// ReasonLoadFailed means the item expired and reloading it failed.
+	ReasonLoadFailed
+	// ReasonTaken means the item was removed by Take.
+	ReasonTaken
+	// ReasonDependency means an item the item depends on changed.
+	ReasonDependency
+	// ReasonClosed means the table was closed.
+	ReasonClosed
+
+	numEvictionReasons
+)
+
+var evictionReasonNames = [numEvictionReasons]string{
+	ReasonDeleted:    "deleted",
+	ReasonExpired:    "expired",
+	ReasonCapacity:   "capacity",
+	ReasonReplaced:   "replaced",
+	ReasonFlushed:    "flushed",
+	ReasonLoadFailed: "load_failed",
+	ReasonTaken:      "taken",
+	ReasonDependency: "dependency",
+	ReasonClosed:     "closed",
+}
+
+// String returns the name of the reason.
+func (r EvictionReason) String() string {
+	if r < 0 || r >= numEvictionReasons {
+		return fmt.Sprintf("EvictionReason(%d)", int(r))
+	}
+	return evictionReasonNames[r]
+}
+
+// MarshalText encodes the reason as its name.
+func (r EvictionReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText decodes a reason from its name.
+func (r *EvictionReason) UnmarshalText(text []byte) error {
+	for i, name := range evictionReasonNames {
+		if name == string(text) {
+			*r = EvictionReason(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("cacher: unknown eviction reason %q", text)
+}
+
+// loadLatencyBuckets are the upper bounds of the load latency histogram.
+var loadLatencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// numLoadBuckets includes the bucket for loads slower than the last bound.
+const numLoadBuckets = len(loadLatencyBuckets) + 1
+
+// LoadLatencyBuckets returns the upper bounds of the buckets of the load
+// latency histogram. Loads slower than the last bound are counted in an
+// additional bucket.
+func LoadLatencyBuckets() []time.Duration {
+	return append([]time.Duration(nil), loadLatencyBuckets[:]...)
+}
+
+// Stats holds the counters of a table. The counters are updated atomically
+// and can be read at any time with Snapshot.
+type Stats struct {
+	hits          int64
+	misses        int64
+	loads         int64
+	loadSuccesses int64
+	loadFailures  int64
+	loadTime      int64
+	sets          int64
+	deletes       int64
+	expirations   int64
+	janitorRuns   int64
+	janitorTime   int64
+	droppedEvents int64
+	evictions     [numEvictionReasons]int64
+	loadLatency   [numLoadBuckets]int64
+}
+
+// StatsSnapshot is a point-in-time copy of a table's Stats.
+type StatsSnapshot struct {
+	// Hits is how many Get calls found their key.
+	Hits int64 `json:"hits"`
+	// Misses is how many Get calls didn't find their key, whether or not it
+	// could be loaded afterwards.
+	Misses int64 `json:"misses"`
+	// Loads is how often the data-loader was called.
+	Loads int64 `json:"loads"`
+	// LoadSuccesses and LoadFailures split Loads by their outcome.
+	LoadSuccesses int64 `json:"loadSuccesses"`
+	LoadFailures  int64 `json:"loadFailures"`
+	// LoadTime is the total time spent in the data-loader.
+	LoadTime time.Duration `json:"loadTime"`
+	// LoadLatency counts the loads per bucket of LoadLatencyBuckets(), plus
+	// the loads slower than the last bucket. It isn't cumulative.
+	LoadLatency []int64 `json:"loadLatency"`
+	// Sets is how many items were stored by Set and Add.
+	Sets int64 `json:"sets"`
+	// Deletes is how many items were removed by Delete.
+	Deletes int64 `json:"deletes"`
+	// Expirations is how many items the expiration check found expired,
+	// including the ones refreshed by the data-loader.
+	Expirations int64 `json:"expirations"`
+	// Evictions counts all items which left the table by reason.
+	Evictions map[EvictionReason]int64 `json:"evictions"`
+	// JanitorRuns is how often the expiration check ran, JanitorTime how
+	// long it took in total.
+	JanitorRuns int64         `json:"janitorRuns"`
+	JanitorTime time.Duration `json:"janitorTime"`
+	// DroppedEvents is how many events were discarded because the buffer of
+	// a subscriber was full.
+	DroppedEvents int64 `json:"droppedEvents"`
+}
+
+// Stats returns the live counters of the table.
+func (table *CacheTable) Stats() *Stats {
+	// immutable
+	return table.stats
+}
+
+// Snapshot copies the current values of the counters. The counters are read
+// one by one, so a snapshot taken under load isn't exactly consistent.
+func (s *Stats) Snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		Hits:          atomic.LoadInt64(&s.hits),
+		Misses:        atomic.LoadInt64(&s.misses),
+		Loads:         atomic.LoadInt64(&s.loads),
+		LoadSuccesses: atomic.LoadInt64(&s.loadSuccesses),
+		LoadFailures:  atomic.LoadInt64(&s.loadFailures),
+		LoadTime:      time.Duration(atomic.LoadInt64(&s.loadTime)),
+		Sets:          atomic.LoadInt64(&s.sets),
+		Deletes:       atomic.LoadInt64(&s.deletes),
+		Expirations:   atomic.LoadInt64(&s.expirations),
+		Evictions:     make(map[EvictionReason]int64, numEvictionReasons),
+		JanitorRuns:   atomic.LoadInt64(&s.janitorRuns),
+		JanitorTime:   time.Duration(atomic.LoadInt64(&s.janitorTime)),
+		DroppedEvents: atomic.LoadInt64(&s.droppedEvents),
+		LoadLatency:   make([]int64, numLoadBuckets),
+	}
+	for i := range s.evictions {
+		snap.Evictions[EvictionReason(i)] = atomic.LoadInt64(&s.evictions[i])
+	}
+	for i := range s.loadLatency {
+		snap.LoadLatency[i] = atomic.LoadInt64(&s.loadLatency[i])
+	}
+	return snap
+}
+
+// Reset sets all counters to zero.
+func (s *Stats) Reset() {
+	for _, p := range []*int64{
+		&s.hits, &s.misses, &s.loads, &s.loadSuccesses, &s.loadFailures,
+		&s.loadTime, &s.sets, &s.deletes, &s.expirations,
+		&s.janitorRuns, &s.janitorTime, &s.droppedEvents,
+	} {
+		atomic.StoreInt64(p, 0)
+	}
+	for i := range s.evictions {
+		atomic.StoreInt64(&s.evictions[i], 0)
+	}
+	for i := range s.loadLatency {
+		atomic.StoreInt64(&s.loadLatency[i], 0)
+	}
+}
+
+// HitRatio returns the share of Get calls which found their key, or 0 if
+// there were none.
+func (s StatsSnapshot) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// TotalEvictions returns how many items left the table for any reason.
+func (s StatsSnapshot) TotalEvictions() int64 {
+	var n int64
+	for _, c := range s.Evictions {
+		n += c
+	}
+	return n
+}
+
+// Sub returns the difference between s and an earlier snapshot prev, i.e.
+// what happened in between.
+func (s StatsSnapshot) Sub(prev StatsSnapshot) StatsSnapshot {
+	diff := StatsSnapshot{
+		Hits:          s.Hits - prev.Hits,
+		Misses:        s.Misses - prev.Misses,
+		Loads:         s.Loads - prev.Loads,
+		LoadSuccesses: s.LoadSuccesses - prev.LoadSuccesses,
+		LoadFailures:  s.LoadFailures - prev.LoadFailures,
+		LoadTime:      s.LoadTime - prev.LoadTime,
+		Sets:          s.Sets - prev.Sets,
+		Deletes:       s.Deletes - prev.Deletes,
+		Expirations:   s.Expirations - prev.Expirations,
+		Evictions:     make(map[EvictionReason]int64, len(s.Evictions)),
+		JanitorRuns:   s.JanitorRuns - prev.JanitorRuns,
+		JanitorTime:   s.JanitorTime - prev.JanitorTime,
+		DroppedEvents: s.DroppedEvents - prev.DroppedEvents,
+		LoadLatency:   make([]int64, len(s.LoadLatency)),
+	}
+	for r, n := range s.Evictions {
+		diff.Evictions[r] = n - prev.Evictions[r]
+	}
+	for i, n := range s.LoadLatency {
+		if i < len(prev.LoadLatency) {
+			n -= prev.LoadLatency[i]
+		}
+		diff.LoadLatency[i] = n
+	}
+	return diff
+}
+
+func (s *Stats) hit() {
+	atomic.AddInt64(&s.hits, 1)
+}
+
+func (s *Stats) miss() {
+	atomic.AddInt64(&s.misses, 1)
+}
+
+// load records a call of the data-loader which took d.
+func (s *Stats) load(d time.Duration, err error) {
+	atomic.AddInt64(&s.loads, 1)
+	atomic.AddInt64(&s.loadTime, int64(d))
+	bucket := len(loadLatencyBuckets)
+	for i, bound := range loadLatencyBuckets {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddInt64(&s.loadLatency[bucket], 1)
+	if err != nil {
+		atomic.AddInt64(&s.loadFailures, 1)
+	} else {
+		atomic.AddInt64(&s.loadSuccesses, 1)
+	}
+}
+
+func (s *Stats) set() {
+	atomic.AddInt64(&s.sets, 1)
+}
+
+func (s *Stats) delete() {
+	atomic.AddInt64(&s.deletes, 1)
+}
+
+func (s *Stats) expire() {
+	atomic.AddInt64(&s.expirations, 1)
+}
+
+// janitor records an expiration check which took d.
+func (s *Stats) janitor(d time.Duration) {
+	atomic.AddInt64(&s.janitorRuns, 1)
+	atomic.AddInt64(&s.janitorTime, int64(d))
+}
+
+func (s *Stats) dropEvent() {
+	atomic.AddInt64(&s.droppedEvents, 1)
+}
+
+func (s *Stats) evict(reason EvictionReason, n int) {
+	atomic.AddInt64(&s.evictions[reason], int64(n))
+}
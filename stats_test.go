@@ -0,0 +1,82 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	table := New("testStats", time.Second)
+	table.SetDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+		if key == "missing" {
+			return nil, 0, errors.New("not found")
+		}
+		return v, 0, nil
+	})
+
+	table.Set(k, 0, v)
+	table.Set(k, 0, v)
+	table.Add(k, 0, v)
+	table.Get(k)
+	table.Get(k)
+	before := table.Stats().Snapshot()
+	table.Get("loaded")
+	table.Get("missing")
+	table.Delete(k)
+	table.Flush()
+
+	s := table.Stats().Snapshot()
+	if s.Hits != 2 || s.Misses != 2 || s.Sets != 2 || s.Deletes != 1 {
+		t.Errorf("Unexpected counters %+v", s)
+	}
+	if s.Loads != 2 || s.LoadSuccesses != 1 || s.LoadFailures != 1 {
+		t.Errorf("Unexpected load counters %+v", s)
+	}
+	if s.Evictions[ReasonReplaced] != 1 || s.Evictions[ReasonDeleted] != 1 || s.Evictions[ReasonFlushed] != 1 {
+		t.Errorf("Unexpected evictions %v", s.Evictions)
+	}
+	if s.HitRatio() != 0.5 {
+		t.Error("Unexpected hit ratio", s.HitRatio())
+	}
+
+	diff := s.Sub(before)
+	if diff.Hits != 0 || diff.Misses != 2 || diff.TotalEvictions() != 2 {
+		t.Errorf("Unexpected diff %+v", diff)
+	}
+
+	table.Stats().Reset()
+	if s := table.Stats().Snapshot(); s.Hits != 0 || s.TotalEvictions() != 0 || s.HitRatio() != 0 {
+		t.Errorf("Counters weren't reset %+v", s)
+	}
+}
+
+func TestStatsExpirations(t *testing.T) {
+	table := newCacheTable("testStatsExpirations", time.Second)
+	table.Set(k, time.Millisecond, v)
+	time.Sleep(2 * time.Millisecond)
+	table.ExpirationCheck()
+
+	s := table.Stats().Snapshot()
+	if s.Expirations != 1 || s.Evictions[ReasonExpired] != 1 {
+		t.Errorf("Unexpected expiration counters %+v", s)
+	}
+}
+
+func TestEvictionReasonJSON(t *testing.T) {
+	b, err := json.Marshal(map[EvictionReason]int{ReasonLoadFailed: 1})
+	if err != nil || string(b) != `{"load_failed":1}` {
+		t.Error("Unexpected encoding", string(b), err)
+	}
+	var m map[EvictionReason]int
+	if err := json.Unmarshal(b, &m); err != nil || m[ReasonLoadFailed] != 1 {
+		t.Error("Unexpected decoding", m, err)
+	}
+}
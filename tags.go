@@ -0,0 +1,119 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"time"
+)
+
+// TaggedData is data with tags, as returned by Tagged.
+type TaggedData struct {
+	Data interface{}
+	Tags []string
+}
+
+// Tagged attaches tags to data returned by a data-loader, so the loaded item
+// carries them.
+func Tagged(data interface{}, tags ...string) TaggedData {
+	return TaggedData{Data: data, Tags: tags}
+}
+
+// SetWithTags is like Set, but attaches tags to the item, which allow
+// invalidating it along with others by InvalidateTag.
+func (table *CacheTable) SetWithTags(key interface{}, lifeSpan time.Duration, data interface{}, tags ...string) *CacheItem {
+	item := table.newItem(key, lifeSpan, data)
+	item.tags = dedupTags(tags)
+
+	table.setInternal(item, nil)
+	return item
+}
+
+// KeysByTag returns the keys of all items with tag.
+func (table *CacheTable) KeysByTag(tag string) []interface{} {
+	table.RLock()
+	defer table.RUnlock()
+	keys := make([]interface{}, 0, len(table.tagIndex[tag]))
+	for key := range table.tagIndex[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// InvalidateTag deletes all items with tag and returns them by key.
+func (table *CacheTable) InvalidateTag(tag string) map[interface{}]*CacheItem {
+	return table.InvalidateTags(tag)
+}
+
+// InvalidateTags deletes all items with any of the tags and returns them by
+// key. Delete callbacks run after the items were removed.
+func (table *CacheTable) InvalidateTags(tags ...string) map[interface{}]*CacheItem {
+	return table.removeMany(ReasonDeleted, func() []interface{} {
+		var keys []interface{}
+		seen := make(map[interface{}]struct{})
+		for _, tag := range tags {
+			for key := range table.tagIndex[tag] {
+				if _, ok := seen[key]; !ok {
+					seen[key] = struct{}{}
+					keys = append(keys, key)
+				}
+			}
+		}
+		return keys
+	})
+}
+
+// newLoadedItem creates the item for data returned by a data-loader,
+// unwrapping TaggedData.
+func (table *CacheTable) newLoadedItem(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	tagged, ok := data.(TaggedData)
+	if !ok {
+		return table.newItem(key, lifeSpan, data)
+	}
+	item := table.newItem(key, lifeSpan, tagged.Data)
+	item.tags = dedupTags(tagged.Tags)
+	return item
+}
+
+// tagInternal adds item to the tag index. Do not run it unless the
+// table-mutex is locked.
+func (table *CacheTable) tagInternal(item *CacheItem) {
+	for _, tag := range item.tags {
+		if table.tagIndex == nil {
+			table.tagIndex = make(map[string]map[interface{}]struct{})
+		}
+		keys := table.tagIndex[tag]
+		if keys == nil {
+			keys = make(map[interface{}]struct{})
+			table.tagIndex[tag] = keys
+		}
+		keys[item.key] = struct{}{}
+	}
+}
+
+// untagInternal removes item from the tag index. Do not run it unless the
+// table-mutex is locked.
+func (table *CacheTable) untagInternal(item *CacheItem) {
+	for _, tag := range item.tags {
+		keys := table.tagIndex[tag]
+		delete(keys, item.key)
+		if len(keys) == 0 {
+			delete(table.tagIndex, tag)
+		}
+	}
+}
+
+func dedupTags(tags []string) []string {
+	var out []string
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		if _, ok := seen[tag]; !ok {
+			seen[tag] = struct{}{}
+			out = append(out, tag)
+		}
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func sortedKeys(keys []interface{}) []string {
+	var s []string
+	for _, k := range keys {
+		s = append(s, k.(string))
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestTags(t *testing.T) {
+	table := newCacheTable("testTags", time.Minute)
+	table.SetWithTags("profile", 0, v, "user:42", "user:42")
+	table.SetWithTags("feed", 0, v, "user:42", "user:7")
+	table.SetWithTags("other", 0, v, "user:7")
+	table.Set("untagged", 0, v)
+
+	if keys := sortedKeys(table.KeysByTag("user:42")); len(keys) != 2 || keys[0] != "feed" || keys[1] != "profile" {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+	if item, _ := table.Peek("profile"); len(item.Tags()) != 1 {
+		t.Errorf("Expected deduplicated tags, got %v", item.Tags())
+	}
+
+	invalidated := table.InvalidateTag("user:42")
+	if len(invalidated) != 2 || table.Exists("profile") || table.Exists("feed") {
+		t.Errorf("Unexpected invalidated items %v", invalidated)
+	}
+	if keys := table.KeysByTag("user:7"); len(keys) != 1 || keys[0] != "other" {
+		t.Errorf("Index wasn't updated on invalidation: %v", keys)
+	}
+
+	// replacing an item replaces its tags
+	table.Set("other", 0, v)
+	if keys := table.KeysByTag("user:7"); len(keys) != 0 {
+		t.Errorf("Replaced item is still indexed: %v", keys)
+	}
+	table.SetWithTags("a", 0, v, "x")
+	table.SetWithTags("b", 0, v, "y")
+	if n := len(table.InvalidateTags("x", "y", "z")); n != 2 || table.Count() != 2 {
+		t.Errorf("Expected 2 invalidated items, got %d", n)
+	}
+}
+
+func TestTagsIndexMaintenance(t *testing.T) {
+	table := newCacheTable("testTagsIndex", time.Minute)
+	table.SetWithTags("deleted", 0, v, "t")
+	table.SetWithTags("expired", time.Nanosecond, v, "t")
+	table.SetWithTags("flushed", 0, v, "f")
+	table.Delete("deleted")
+	time.Sleep(time.Millisecond)
+	table.ExpirationCheck()
+	if keys := table.KeysByTag("t"); len(keys) != 0 {
+		t.Errorf("Removed items are still indexed: %v", keys)
+	}
+	table.Flush()
+	if keys := table.KeysByTag("f"); len(keys) != 0 {
+		t.Errorf("Flushed items are still indexed: %v", keys)
+	}
+}
+
+func TestTaggedLoader(t *testing.T) {
+	table := newCacheTable("testTaggedLoader", time.Minute)
+	table.SetDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+		return Tagged(v, "loaded"), 0, nil
+	})
+	item, err := table.Get(k)
+	if err != nil || item.Data() != v {
+		t.Fatalf("Get returned %v, %v", item, err)
+	}
+	if keys := table.KeysByTag("loaded"); len(keys) != 1 || keys[0] != k {
+		t.Errorf("Loaded item wasn't tagged: %v", keys)
+	}
+}
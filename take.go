@@ -0,0 +1,29 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+// Take atomically removes an item from the cache and returns it, so of
+// concurrent callers only one gets it. Delete callbacks run with
+// ReasonTaken after the item was removed.
+func (table *CacheTable) Take(key interface{}) (*CacheItem, error) {
+	if table.Closed() {
+		return nil, ErrTableClosed
+	}
+	taken := table.TakeMany(key)
+	if r, ok := taken[key]; ok {
+		return r, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// TakeMany is like Take for several keys at once. It returns the items it
+// removed by key; missing keys are left out.
+func (table *CacheTable) TakeMany(keys ...interface{}) map[interface{}]*CacheItem {
+	return table.removeMany(ReasonTaken, func() []interface{} {
+		return keys
+	})
+}
@@ -0,0 +1,80 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	table := newCacheTable("testTake", time.Minute)
+	var reason EvictionReason = -1
+	table.SetAboutToDeleteItemWithReasonCallback(func(item *CacheItem, r EvictionReason) {
+		reason = r
+	})
+	table.Set(k, 0, v)
+
+	item, err := table.Take(k)
+	if err != nil || item.Data() != v {
+		t.Fatalf("Take returned %v, %v", item, err)
+	}
+	if table.Exists(k) {
+		t.Error("Taken item is still cached")
+	}
+	if reason != ReasonTaken {
+		t.Errorf("Expected reason %v, got %v", ReasonTaken, reason)
+	}
+	if _, err := table.Take(k); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if n := table.Stats().Snapshot().Evictions[ReasonTaken]; n != 1 {
+		t.Errorf("Expected 1 taken eviction, got %d", n)
+	}
+}
+
+func TestTakeMany(t *testing.T) {
+	table := newCacheTable("testTakeMany", time.Minute)
+	table.Set("a", 0, 1)
+	table.Set("b", 0, 2)
+	table.Set("c", 0, 3)
+
+	taken := table.TakeMany("a", "b", "missing")
+	if len(taken) != 2 || taken["a"].Data() != 1 || taken["b"].Data() != 2 {
+		t.Errorf("Unexpected taken items %v", taken)
+	}
+	if table.Count() != 1 || !table.Exists("c") {
+		t.Error("TakeMany removed the wrong items")
+	}
+}
+
+func TestTakeConcurrent(t *testing.T) {
+	table := newCacheTable("testTakeConcurrent", time.Minute)
+	// slow callbacks must not let another caller take the item as well
+	table.SetAboutToDeleteItemCallback(func(item *CacheItem) {
+		time.Sleep(10 * time.Millisecond)
+	})
+	table.Set(k, 0, v)
+
+	var wg sync.WaitGroup
+	var winners int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := table.Take(k); err == nil {
+				atomic.AddInt32(&winners, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if winners != 1 {
+		t.Errorf("Expected exactly one caller to take the item, got %d", winners)
+	}
+}
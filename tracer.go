@@ -0,0 +1,172 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Op identifies the operation traced by a Span.
+type Op int
+
+const (
+	// OpGet is a Get call, including a load it may trigger.
+	OpGet Op = iota
+	// OpLoad is a call of the data-loader, or waiting for the load of
+	// another caller of the same key.
+	OpLoad
+	// OpSet stores an item via Set or Add.
+	OpSet
+	// OpDelete is a Delete call.
+	OpDelete
+	// OpEvict removes an item for any reason, running its callbacks.
+	OpEvict
+	// OpJanitor is an expiration check.
+	OpJanitor
+)
+
+var opNames = [...]string{
+	OpGet:     "get",
+	OpLoad:    "load",
+	OpSet:     "set",
+	OpDelete:  "delete",
+	OpEvict:   "evict",
+	OpJanitor: "janitor",
+}
+
+// String returns the name of the operation.
+func (o Op) String() string {
+	if o < 0 || int(o) >= len(opNames) {
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+	return opNames[o]
+}
+
+// Span describes a traced operation. The fields documented as results are
+// only valid in Tracer.End.
+type Span struct {
+	// Op is the traced operation.
+	Op Op
+	// Table is the name of the table.
+	Table string
+	// Key is the key the operation works on. It is nil for janitor passes.
+	Key interface{}
+	// Start is when the operation began, including the time it waited for
+	// the table lock.
+	Start time.Time
+	// LockWait is how long the operation waited for the table lock, e.g.
+	// behind an expiration check.
+	LockWait time.Duration
+	// Reason is why an item got evicted, for OpEvict.
+	Reason EvictionReason
+
+	// Hit reports whether Get found the key without loading it. Result.
+	Hit bool
+	// Shared reports whether a load waited for the data-loader call of
+	// another caller (a singleflight duplicate) instead of calling it.
+	// Result.
+	Shared bool
+	// Items is how many items a janitor pass examined. Result.
+	Items int
+	// Err is the error the operation failed with, if any. Result.
+	Err error
+}
+
+// Tracer gets notified when operations on a table start and end. It can be
+// used to bridge to tracing systems such as OpenTelemetry.
+//
+// Tracers are called synchronously, partly while the table is locked. They
+// must be safe for concurrent use and must not call back into the table.
+type Tracer interface {
+	// Start is called when an operation begins. The returned context is
+	// passed to End and is the parent of nested operations, e.g. the load
+	// triggered by a Get.
+	Start(ctx context.Context, span *Span) context.Context
+	// End is called when the operation is done.
+	End(ctx context.Context, span *Span)
+}
+
+// SetTracer sets the tracer of this table. Passing nil disables tracing.
+func (table *CacheTable) SetTracer(tracer Tracer) {
+	table.Lock()
+	defer table.Unlock()
+	table.tracer = tracer
+}
+
+// trace is a started span. All methods are no-ops on a nil trace, which is
+// what startTrace returns without a tracer.
+type trace struct {
+	tracer Tracer
+	ctx    context.Context
+	span   Span
+}
+
+func (table *CacheTable) startTrace(ctx context.Context, tracer Tracer, op Op, key interface{}) *trace {
+	return table.startTraceAt(ctx, tracer, op, key, time.Now())
+}
+
+// startTraceAt starts a span for an operation which began at start, before
+// it took the table lock.
+func (table *CacheTable) startTraceAt(ctx context.Context, tracer Tracer, op Op, key interface{}, start time.Time) *trace {
+	if tracer == nil {
+		return nil
+	}
+	t := &trace{
+		tracer: tracer,
+		span: Span{
+			Op:       op,
+			Table:    table.name,
+			Key:      key,
+			Start:    start,
+			LockWait: time.Since(start),
+		},
+	}
+	t.ctx = tracer.Start(ctx, &t.span)
+	return t
+}
+
+// context returns the context for nested spans.
+func (t *trace) context(parent context.Context) context.Context {
+	if t == nil {
+		return parent
+	}
+	return t.ctx
+}
+
+func (t *trace) end(err error) {
+	if t == nil {
+		return
+	}
+	t.span.Err = err
+	t.tracer.End(t.ctx, &t.span)
+}
+
+func (t *trace) hit() {
+	if t != nil {
+		t.span.Hit = true
+	}
+}
+
+func (t *trace) shared(shared bool) {
+	if t != nil {
+		t.span.Shared = shared
+	}
+}
+
+func (t *trace) reason(reason EvictionReason) {
+	if t != nil {
+		t.span.Reason = reason
+	}
+}
+
+func (t *trace) items(n int) {
+	if t != nil {
+		t.span.Items = n
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+// recordingTracer keeps every ended span along with its parent's op.
+type recordingTracer struct {
+	sync.Mutex
+	spans   []Span
+	parents []Op
+}
+
+type tracedOp struct {
+	op     Op
+	parent Op
+}
+
+func (r *recordingTracer) Start(ctx context.Context, span *Span) context.Context {
+	parent := Op(-1)
+	if p, ok := ctx.Value(ctxKey{}).(tracedOp); ok {
+		parent = p.op
+	}
+	return context.WithValue(ctx, ctxKey{}, tracedOp{span.Op, parent})
+}
+
+func (r *recordingTracer) End(ctx context.Context, span *Span) {
+	r.Lock()
+	defer r.Unlock()
+	r.spans = append(r.spans, *span)
+	r.parents = append(r.parents, ctx.Value(ctxKey{}).(tracedOp).parent)
+}
+
+func (r *recordingTracer) find(op Op) []Span {
+	r.Lock()
+	defer r.Unlock()
+	var spans []Span
+	for _, s := range r.spans {
+		if s.Op == op {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	table := newCacheTable("testTracer", time.Second)
+	table.SetTracer(tracer)
+	table.SetDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+		return v, 0, nil
+	})
+
+	table.Set(k, 0, v)
+	table.Get(k)
+	table.GetContext(context.Background(), "loaded")
+	table.Delete(k)
+	table.Set("expiring", time.Nanosecond, v)
+	time.Sleep(time.Millisecond)
+	table.ExpirationCheck()
+
+	gets := tracer.find(OpGet)
+	if len(gets) != 2 || !gets[0].Hit || gets[1].Hit {
+		t.Errorf("Unexpected get spans %+v", gets)
+	}
+	if loads := tracer.find(OpLoad); len(loads) != 1 || loads[0].Key != "loaded" || loads[0].Shared {
+		t.Errorf("Unexpected load spans %+v", loads)
+	}
+	if sets := tracer.find(OpSet); len(sets) != 2 {
+		t.Errorf("Unexpected set spans %+v", sets)
+	}
+	if deletes := tracer.find(OpDelete); len(deletes) != 1 || deletes[0].Err != nil {
+		t.Errorf("Unexpected delete spans %+v", deletes)
+	}
+	evictions := tracer.find(OpEvict)
+	if len(evictions) != 2 || evictions[0].Reason != ReasonDeleted || evictions[1].Reason != ReasonExpired {
+		t.Errorf("Unexpected evict spans %+v", evictions)
+	}
+	if janitor := tracer.find(OpJanitor); len(janitor) != 1 || janitor[0].Items != 2 {
+		t.Errorf("Unexpected janitor spans %+v", janitor)
+	}
+	for i, s := range tracer.spans {
+		if s.Op == OpLoad && tracer.parents[i] != OpGet {
+			t.Error("Load span isn't nested in its get span")
+		}
+	}
+}
+
+func TestTracerSharedLoad(t *testing.T) {
+	tracer := &recordingTracer{}
+	table := newCacheTable("testTracerShared", time.Second)
+	table.SetTracer(tracer)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	table.SetDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+		close(started)
+		<-release
+		return v, 0, nil
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		table.Get(k)
+	}()
+	<-started
+	go func() {
+		defer wg.Done()
+		table.Get(k)
+	}()
+	// give the second caller time to join the load
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	loads := tracer.find(OpLoad)
+	if len(loads) != 2 || loads[0].Shared == loads[1].Shared {
+		t.Errorf("Expected exactly one shared load, got %+v", loads)
+	}
+}
+
+func TestTracerLockWait(t *testing.T) {
+	tracer := &recordingTracer{}
+	table := newCacheTable("testTracerLockWait", time.Second)
+	table.SetTracer(tracer)
+	table.Set(k, 0, v)
+
+	// Hold the lock like a long expiration check would.
+	table.Lock()
+	before := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		table.Get(k)
+	}()
+	go func() {
+		defer wg.Done()
+		table.Set("other", 0, v)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	table.Unlock()
+	wg.Wait()
+
+	for _, op := range []Op{OpGet, OpSet} {
+		spans := tracer.find(op)
+		s := spans[len(spans)-1]
+		if s.LockWait < 10*time.Millisecond || s.Start.After(before.Add(10*time.Millisecond)) {
+			t.Errorf("%v span doesn't cover the lock wait: %+v", op, s)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"time"
+)
+
+// NoExpiration is the TTL of items without a lifespan.
+const NoExpiration time.Duration = -1
+
+// Peek returns an item from the cache like Get, but neither marks it to be
+// kept alive, nor counts it in the stats, nor tries to load a missing key.
+func (table *CacheTable) Peek(key interface{}) (*CacheItem, error) {
+	table.RLock()
+	defer table.RUnlock()
+	if table.closed {
+		return nil, ErrTableClosed
+	}
+	if r, ok := table.items[key]; ok {
+		return r, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Touch sets the lifespan of an item, which then expires lifeSpan from now.
+// A lifeSpan of 0 or NoExpiration makes it persistent.
+func (table *CacheTable) Touch(key interface{}, lifeSpan time.Duration) (*CacheItem, error) {
+	r, err := table.Peek(key)
+	if err != nil {
+		return nil, err
+	}
+	if lifeSpan <= 0 {
+		// Stored as 0, the lifespan would read as the table default.
+		lifeSpan = NoExpiration
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.lifeSpan = lifeSpan
+	r.expiresAt = expiry(table.clock.Now(), lifeSpan)
+	return r, nil
+}
+
+// Persist removes the expiry of an item.
+func (table *CacheTable) Persist(key interface{}) (*CacheItem, error) {
+	return table.Touch(key, NoExpiration)
+}
+
+// TTL returns how long an item has left until it expires, NoExpiration if it
+// doesn't expire, or 0 if it expired and awaits the next expiration check.
+func (table *CacheTable) TTL(key interface{}) (time.Duration, error) {
+	r, err := table.Peek(key)
+	if err != nil {
+		return 0, err
+	}
+	expiresAt := r.ExpiresAt()
+	if expiresAt.IsZero() {
+		return NoExpiration, nil
+	}
+	if d := expiresAt.Sub(table.clock.Now()); d > 0 {
+		return d, nil
+	}
+	return 0, nil
+}
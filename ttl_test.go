@@ -0,0 +1,77 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmallSmartMouse/cacher/cachertest"
+)
+
+func TestPeek(t *testing.T) {
+	table := newCacheTable("testPeek", time.Minute)
+	loads := 0
+	table.SetDataLoader(func(key interface{}) (interface{}, time.Duration, error) {
+		loads++
+		return v, 0, nil
+	})
+	table.Set(k, 0, v)
+
+	item, err := table.Peek(k)
+	if err != nil || item.Data() != v || item.AccessCount() != 0 {
+		t.Errorf("Peek returned %v, %v with %d accesses", item, err, item.AccessCount())
+	}
+	if _, err := table.Peek("missing"); err != ErrKeyNotFound || loads != 0 {
+		t.Errorf("Expected ErrKeyNotFound without loading, got %v after %d loads", err, loads)
+	}
+	if s := table.Stats().Snapshot(); s.Hits != 0 || s.Misses != 0 {
+		t.Errorf("Peek was counted in the stats: %+v", s)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	clock := cachertest.NewFakeClock(time.Now())
+	table := newCacheTable("testTouch", time.Minute)
+	table.clock = clock
+	item := table.Set(k, 50*time.Millisecond, v)
+	if !item.ExpiresAt().Equal(item.CreatedOn().Add(50 * time.Millisecond)) {
+		t.Errorf("Unexpected expiry %v", item.ExpiresAt())
+	}
+
+	clock.Advance(40 * time.Millisecond)
+	if _, err := table.Touch(k, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(20 * time.Millisecond)
+	table.ExpirationCheck()
+	if !table.Exists(k) {
+		t.Error("Touched item expired")
+	}
+	if ttl, _ := table.TTL(k); ttl != 30*time.Millisecond {
+		t.Errorf("Unexpected TTL %v", ttl)
+	}
+
+	if _, err := table.Persist(k); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(50 * time.Millisecond)
+	table.ExpirationCheck()
+	if ttl, err := table.TTL(k); err != nil || ttl != NoExpiration {
+		t.Errorf("Expected a persistent item, got TTL %v, %v", ttl, err)
+	}
+	if !item.ExpiresAt().IsZero() || item.LifeSpan() != NoExpiration {
+		t.Error("Persisted item still has an expiry")
+	}
+
+	if _, err := table.Touch("missing", time.Second); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := table.TTL("missing"); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// GetWithVersion is like Get, but also returns the version of the item, to
+// be passed to CompareAndSwap or CompareAndDelete.
+func (table *CacheTable) GetWithVersion(key interface{}) (*CacheItem, uint64, error) {
+	item, err := table.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return item, item.Version(), nil
+}
+
+// CompareAndSwap stores data under key if the version of the current item is
+// expectedVersion, and returns the new item. An expectedVersion of 0 only
+// succeeds if the key doesn't exist. Otherwise it returns ErrKeyNotFound if
+// the key doesn't exist and ErrVersionMismatch if it changed meanwhile.
+func (table *CacheTable) CompareAndSwap(key interface{}, expectedVersion uint64, data interface{}, lifeSpan time.Duration) (*CacheItem, error) {
+	item := table.newItem(key, lifeSpan, data)
+	err := table.setInternal(item, func(old *CacheItem) error {
+		return checkVersion(old, expectedVersion)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// CompareAndDelete deletes key if the version of its item is
+// expectedVersion. It returns ErrKeyNotFound if the key doesn't exist and
+// ErrVersionMismatch if it changed meanwhile.
+func (table *CacheTable) CompareAndDelete(key interface{}, expectedVersion uint64) (*CacheItem, error) {
+	start := time.Now()
+	table.Lock()
+	tr := table.startTraceAt(context.Background(), table.tracer, OpDelete, key, start)
+	err := checkVersion(table.items[key], expectedVersion)
+	if table.closed {
+		err = ErrTableClosed
+	} else if err == nil && expectedVersion == 0 {
+		err = ErrKeyNotFound
+	}
+	var r *CacheItem
+	if err == nil {
+		r, err = table.deleteInternal(key, ReasonDeleted)
+	}
+	table.Unlock()
+	tr.end(err)
+	if err != nil {
+		return nil, err
+	}
+
+	table.stats.delete()
+	table.publishKey(key)
+	return r, nil
+}
+
+func checkVersion(item *CacheItem, expectedVersion uint64) error {
+	switch {
+	case item == nil && expectedVersion == 0:
+		return nil
+	case item == nil:
+		return ErrKeyNotFound
+	case item.Version() != expectedVersion:
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+func (table *CacheTable) nextVersion() uint64 {
+	return atomic.AddUint64(&table.version, 1)
+}
@@ -0,0 +1,73 @@
+/*
+ * Simple caching library with expiration capabilities
+ *
+ *   For license see LICENSE.txt
+ */
+
+package cacher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	table := newCacheTable("testCompareAndSwap", time.Minute)
+
+	if _, err := table.CompareAndSwap(k, 1, v, 0); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	created, err := table.CompareAndSwap(k, 0, "first", 0)
+	if err != nil || created.Version() == 0 {
+		t.Fatalf("Creating with version 0 failed: %v", err)
+	}
+	if _, err := table.CompareAndSwap(k, 0, "again", 0); err != ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch when creating twice, got %v", err)
+	}
+
+	_, version, err := table.GetWithVersion(k)
+	if err != nil || version != created.Version() {
+		t.Fatalf("GetWithVersion returned %d, %v", version, err)
+	}
+	swapped, err := table.CompareAndSwap(k, version, "second", time.Hour)
+	if err != nil || swapped.Version() <= version {
+		t.Fatalf("Swap failed: %v", err)
+	}
+	if _, err := table.CompareAndSwap(k, version, "stale", 0); err != ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch for a stale version, got %v", err)
+	}
+	if item, _ := table.Get(k); item.Data() != "second" || item.LifeSpan() != time.Hour {
+		t.Errorf("Unexpected item %v", item.Data())
+	}
+
+	// in-place changes bump the version as well
+	table.Set("n", 0, 1)
+	_, version, _ = table.GetWithVersion("n")
+	table.Increment("n", 1)
+	if _, err := table.CompareAndSwap("n", version, 5, 0); err != ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch after Increment, got %v", err)
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	table := newCacheTable("testCompareAndDelete", time.Minute)
+	old := table.Set(k, 0, v)
+	table.Set(k, 0, v)
+
+	if _, err := table.CompareAndDelete(k, old.Version()); err != ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch, got %v", err)
+	}
+	_, version, _ := table.GetWithVersion(k)
+	if _, err := table.CompareAndDelete(k, version); err != nil {
+		t.Errorf("CompareAndDelete failed: %v", err)
+	}
+	if table.Exists(k) {
+		t.Error("Item wasn't deleted")
+	}
+	if _, err := table.CompareAndDelete(k, version); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := table.CompareAndDelete(k, 0); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound for version 0, got %v", err)
+	}
+}